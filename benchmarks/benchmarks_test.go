@@ -1,6 +1,7 @@
 package kvlogbenchmarks
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -71,7 +72,7 @@ func BenchmarkKVLog_asyncHandler(b *testing.B) {
 		)
 	}
 
-	h.Close()
+	h.Close(context.Background())
 }
 
 func BenchmarkZerolog(b *testing.B) {
@@ -48,3 +48,23 @@ func BenchmarkKVLog_syncHandler_noopFormatter(b *testing.B) {
 		)
 	}
 }
+
+func BenchmarkKVLog_syncHandler_JSONLFormatter_typedPairs(b *testing.B) {
+	out, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+
+	l := kvlog.New(kvlog.NewSyncHandler(out, kvlog.JSONLFormatter())).AddHook(kvlog.TimeHook)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Logs("some message",
+			kvlog.WithStr("spam", "eggs"),
+			kvlog.WithInt("foo", 17),
+			kvlog.WithBool("enabled", true),
+			kvlog.WithDur(time.Second),
+		)
+	}
+}
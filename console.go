@@ -20,7 +20,9 @@ package kvlog
 import (
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,48 +37,143 @@ func ConsoleFormatter() Formatter {
 
 		sort.Sort(pairs)
 
+		// Multi-line values such as stack traces and error causes are written as indented blocks after all
+		// other pairs.
+		var blocks []string
+
 		for i, p := range pairs {
+			v := p.Any()
+
+			if st, ok := v.(Stack); ok {
+				blocks = append(blocks, "\x1b[90m"+p.Key+":"+formatConsoleStack(st)+"\x1b[0m")
+				continue
+			}
+
 			if i > 0 {
 				fmt.Fprint(w, " ")
 			}
 
-			if p.Key == KeyTime {
-				t := p.Value.(time.Time).Sub(start)
-				_, err = fmt.Fprintf(w, "\x1b[90m%s:%v\x1b[0m", p.Key, t)
+			if t, ok := v.(time.Time); ok && p.Key == KeyTime {
+				_, err = fmt.Fprintf(w, "\x1b[90m%s:%v\x1b[0m", p.Key, t.Sub(start))
 				if err != nil {
 					return
 				}
 
 			} else {
-				var valueColor string
-				switch p.Value.(type) {
-				case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
-					valueColor = "1;34"
-				case time.Duration:
-					valueColor = "1;36"
-				case error:
-					valueColor = "1;31"
-				default:
-					valueColor = "97"
+				var value interface{} = v
+				if v == nil {
+					value = "null"
+				} else if c, ok := v.(Caller); ok {
+					value = filepath.Base(c.File) + ":" + strconv.Itoa(c.Line)
+				} else if s, ok := textValue(v); ok {
+					value = s
 				}
 
-				_, err = fmt.Fprintf(w, "\x1b[90m%s:\x1b[0m\x1b[%sm%v\x1b[0m", p.Key, valueColor, p.Value)
+				if e, ok := v.(error); ok && !isNilPointer(e) && len(causes(e)) > 0 {
+					blocks = append(blocks, "\x1b[90m"+p.Key+":"+formatConsoleCauses(e, 1)+"\x1b[0m")
+				}
+
+				_, err = fmt.Fprintf(w, "\x1b[90m%s:\x1b[0m\x1b[%sm%v\x1b[0m", p.Key, valueColor(p), value)
 				if err != nil {
 					return
 				}
 			}
 		}
 
+		for _, b := range blocks {
+			if _, err = fmt.Fprintf(w, "\n%s", b); err != nil {
+				return
+			}
+		}
+
 		_, err = w.Write([]byte("\n"))
 
 		return
 	})
 }
 
+func valueColor(p Pair) string {
+	switch p.Kind() {
+	case KindInt64, KindUint64, KindFloat64:
+		return "1;34"
+	case KindBool:
+		return "1;35"
+	case KindDuration:
+		return "1;36"
+	case KindAny:
+		switch x := p.Value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			return "1;34"
+		case bool, nil:
+			return "1;35"
+		case time.Duration:
+			return "1;36"
+		case error:
+			return "1;31"
+		case Level:
+			return levelColor(x)
+		case Caller, Stack:
+			return "90"
+		}
+	}
+
+	return "97"
+}
+
+// formatConsoleStack formats s as an indented block starting on a new line.
+func formatConsoleStack(s Stack) string {
+	var b strings.Builder
+	for _, f := range s {
+		b.WriteString("\n    ")
+		b.WriteString(f.Function)
+		b.WriteString("\n        ")
+		b.WriteString(f.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(f.Line))
+	}
+	return b.String()
+}
+
+// formatConsoleCauses formats the errors wrapped by err as an indented block starting on a new line. Each
+// level of wrapping increases the indentation.
+func formatConsoleCauses(err error, depth int) string {
+	var b strings.Builder
+	for _, c := range causes(err) {
+		b.WriteString("\n")
+		b.WriteString(strings.Repeat("    ", depth))
+		if c == nil || isNilPointer(c) {
+			b.WriteString("caused by: null")
+			continue
+		}
+		b.WriteString("caused by: ")
+		b.WriteString(c.Error())
+		b.WriteString(" (")
+		b.WriteString(errorType(c))
+		b.WriteString(")")
+		b.WriteString(formatConsoleCauses(c, depth+1))
+	}
+	return b.String()
+}
+
+func levelColor(l Level) string {
+	switch {
+	case l >= LevelError:
+		return "1;31"
+	case l >= LevelWarn:
+		return "1;33"
+	case l >= LevelInfo:
+		return "1;32"
+	default:
+		return "90"
+	}
+}
+
 func collectPairs(e *Event) []Pair {
 	pairs := make([]Pair, 0, e.Len())
 	e.EachPair(func(p Pair) {
-		pairs = append(pairs, p)
+		eachFlatPair(p, func(p Pair) {
+			pairs = append(pairs, p)
+		})
 	})
 	return pairs
 }
@@ -86,6 +183,14 @@ type sorted []Pair
 func (s sorted) Len() int      { return len(s) }
 func (s sorted) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s sorted) Less(i, j int) bool {
+	// Stack traces span multiple lines and are put last.
+	if s[i].Key == KeyStack {
+		return false
+	}
+	if s[j].Key == KeyStack {
+		return true
+	}
+
 	if s[i].Key == KeyTime {
 		return true
 	}
@@ -93,6 +198,13 @@ func (s sorted) Less(i, j int) bool {
 		return false
 	}
 
+	if s[i].Key == KeyLevel {
+		return true
+	}
+	if s[j].Key == KeyLevel {
+		return false
+	}
+
 	if s[i].Key == KeyMessage {
 		return true
 	}
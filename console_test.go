@@ -19,6 +19,8 @@ package kvlog
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -35,3 +37,69 @@ func TestConsoleFormatter(t *testing.T) {
 		t.Errorf("\nwant: %s\ngot:  %s", want, buf.String())
 	}
 }
+
+func TestConsoleFormatter_group(t *testing.T) {
+	evt := newEvent()
+	evt.AddPair(WithGroup("http", WithKV("status", 200), WithKV("method", "GET")))
+
+	want := "\x1b[90mhttp.method:\x1b[0m\x1b[97mGET\x1b[0m \x1b[90mhttp.status:\x1b[0m\x1b[1;34m200\x1b[0m\n"
+	var buf bytes.Buffer
+	if err := ConsoleFormatter().Format(&buf, evt); err != nil {
+		t.Errorf("failed to format message: %s", err)
+	} else if want != buf.String() {
+		t.Errorf("\nwant: %s\ngot:  %s", want, buf.String())
+	}
+}
+
+func TestConsoleFormatter_caller(t *testing.T) {
+	evt := newEvent()
+	evt.AddPair(WithKV(KeyCaller, Caller{File: "/src/app/main.go", Line: 17}))
+
+	want := "\x1b[90mcaller:\x1b[0m\x1b[90mmain.go:17\x1b[0m\n"
+	var buf bytes.Buffer
+	if err := ConsoleFormatter().Format(&buf, evt); err != nil {
+		t.Errorf("failed to format message: %s", err)
+	} else if want != buf.String() {
+		t.Errorf("\nwant: %s\ngot:  %s", want, buf.String())
+	}
+}
+
+func TestConsoleFormatter_stack(t *testing.T) {
+	evt := newEvent()
+	evt.AddPair(WithKV(KeyStack, Stack{{Function: "main.main", File: "/src/app/main.go", Line: 17}}))
+	evt.AddPair(WithKV(KeyMessage, "failed"))
+
+	want := "\x1b[90mmsg:\x1b[0m\x1b[97mfailed\x1b[0m\n\x1b[90mstack:\n    main.main\n        /src/app/main.go:17\x1b[0m\n"
+	var buf bytes.Buffer
+	if err := ConsoleFormatter().Format(&buf, evt); err != nil {
+		t.Errorf("failed to format message: %s", err)
+	} else if want != buf.String() {
+		t.Errorf("\nwant: %q\ngot:  %q", want, buf.String())
+	}
+}
+
+type multiError []error
+
+func (m multiError) Error() string   { return "multiple errors" }
+func (m multiError) Unwrap() []error { return m }
+
+func TestConsoleFormatter_errorChain(t *testing.T) {
+	inner := errors.New("inner")
+	err := fmt.Errorf("outer: %w", multiError{inner, fmt.Errorf("other: %w", inner)})
+
+	evt := newEvent()
+	evt.AddPair(WithErr(err))
+
+	want := "\x1b[90merr:\x1b[0m\x1b[1;31mouter: multiple errors\x1b[0m\n\x1b[90merr:" +
+		"\n    caused by: multiple errors (kvlog.multiError)" +
+		"\n        caused by: inner (*errors.errorString)" +
+		"\n        caused by: other: inner (*fmt.wrapError)" +
+		"\n            caused by: inner (*errors.errorString)" +
+		"\x1b[0m\n"
+	var buf bytes.Buffer
+	if err := ConsoleFormatter().Format(&buf, evt); err != nil {
+		t.Errorf("failed to format message: %s", err)
+	} else if want != buf.String() {
+		t.Errorf("\nwant: %q\ngot:  %q", want, buf.String())
+	}
+}
@@ -24,3 +24,22 @@ func TestContext(t *testing.T) {
 		t.Errorf("unexpected log output %q", buf.String())
 	}
 }
+
+func TestContext_levels(t *testing.T) {
+	var buf strings.Builder
+	lo := NewWithOptions(Options{MinLevel: LevelInfo}, NewSyncHandler(&buf, JSONLFormatter()))
+
+	ctx := context.Background()
+
+	if FromContext(ctx).Enabled(LevelError) {
+		t.Errorf("expected logger without context to disable all levels")
+	}
+
+	l := FromContext(ContextWithLogger(ctx, lo))
+	l.Debug("1")
+	l.Info("2")
+
+	if strings.TrimSpace(buf.String()) != `{"level":"info","msg":"2"}` {
+		t.Errorf("unexpected log output %q", buf.String())
+	}
+}
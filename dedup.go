@@ -0,0 +1,179 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type dedupHandler struct {
+	handler Handler
+	window  time.Duration
+	keys    []string
+
+	mtx      sync.Mutex
+	last     *Event
+	first    time.Time
+	latest   time.Time
+	repeated int
+	timer    *time.Timer
+	run      int
+}
+
+// NewDedupHandler creates a Handler that suppresses consecutive duplicate events delivered to h. An event is a
+// duplicate of the previously delivered one if both have the same message, level and values for all of the
+// given keys. Errors are compared by their message.
+//
+// Once a different event arrives, the window elapses (measured from the first event of a series of
+// duplicates) or the Handler is closed, a summary event is delivered to h if any duplicates have been
+// suppressed. The summary is a copy of the first event with the message extended by the number of repetitions
+// and the time span they occurred in (e.g. "timeout (repeated 431 times over 10s)"). The number of repetitions
+// is added with key KeyRepeated. If window is not positive, series of duplicates only end when a different
+// event arrives or the Handler is closed.
+func NewDedupHandler(h Handler, window time.Duration, keys ...string) Handler {
+	return &dedupHandler{
+		handler: h,
+		window:  window,
+		keys:    keys,
+	}
+}
+
+func (h *dedupHandler) Flush(ctx context.Context) error {
+	return flushHandler(ctx, h.handler)
+}
+
+func (h *dedupHandler) Close(ctx context.Context) error {
+	h.mtx.Lock()
+	h.endRun()
+	h.mtx.Unlock()
+
+	return h.handler.Close(ctx)
+}
+
+func (h *dedupHandler) Handle(e *Event) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.last != nil && h.isDuplicate(e) {
+		h.repeated++
+		h.latest = time.Now()
+		return nil
+	}
+
+	h.endRun()
+
+	h.last = e.Clone()
+	h.first = time.Now()
+	h.run++
+
+	if h.window > 0 {
+		run := h.run
+		h.timer = time.AfterFunc(h.window, func() {
+			h.mtx.Lock()
+			defer h.mtx.Unlock()
+
+			if h.run == run {
+				h.endRun()
+			}
+		})
+	}
+
+	return h.handler.Handle(e)
+}
+
+// endRun ends the current series of duplicates and delivers a summary if any duplicates have been suppressed.
+// h.mtx must be held by the caller.
+func (h *dedupHandler) endRun() {
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+
+	if h.last != nil && h.repeated > 0 {
+		// Errors are ignored just as errors from other Handlers are by the Logger.
+		h.handler.Handle(h.summary())
+	}
+
+	h.last = nil
+	h.repeated = 0
+}
+
+// summary creates the summary event for the current series of duplicates.
+func (h *dedupHandler) summary() *Event {
+	s := cloneWith(h.last, Pair{Key: KeyRepeated, kind: KindInt64, num: uint64(h.repeated)})
+
+	times := "times"
+	if h.repeated == 1 {
+		times = "time"
+	}
+
+	msg := fmt.Sprintf("(repeated %d %s over %s)", h.repeated, times, h.latest.Sub(h.first).Round(time.Millisecond))
+	if i := s.index(KeyMessage); i >= 0 {
+		msg = messageOf(s.pairs[i]) + " " + msg
+		s.pairs[i] = Pair{Key: KeyMessage, kind: KindString, str: msg}
+	} else {
+		s.add(Pair{Key: KeyMessage, kind: KindString, str: msg})
+	}
+
+	if i := s.index(KeyTime); i >= 0 && s.pairs[i].kind == KindTime {
+		s.pairs[i] = timePair(KeyTime, h.latest)
+	}
+
+	return s
+}
+
+// isDuplicate reports whether e is a duplicate of h.last.
+func (h *dedupHandler) isDuplicate(e *Event) bool {
+	if !h.sameValue(e, KeyMessage) || !h.sameValue(e, KeyLevel) {
+		return false
+	}
+
+	for _, k := range h.keys {
+		if !h.sameValue(e, k) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameValue reports whether e and h.last either both lack key or both contain equal values for key. Errors
+// are compared by their message as errors created for each event (i.e. with fmt.Errorf) never compare equal.
+func (h *dedupHandler) sameValue(e *Event, key string) bool {
+	a, aok := h.last.Value(key)
+	b, bok := e.Value(key)
+
+	if aok != bok {
+		return false
+	}
+
+	if !aok {
+		return true
+	}
+
+	if ea, ok := a.(error); ok && !isNilPointer(a) {
+		if eb, ok := b.(error); ok && !isNilPointer(b) {
+			return ea.Error() == eb.Error()
+		}
+	}
+
+	return valuesEqual(a, b)
+}
@@ -0,0 +1,117 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestDedupHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := kvlog.NewDedupHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter), 0, "host")
+	l := kvlog.New(h)
+
+	for i := 0; i < 3; i++ {
+		l.Error("timeout", kvlog.WithStr("host", "a"), kvlog.WithInt("attempt", i))
+	}
+	l.Error("timeout", kvlog.WithStr("host", "b"))
+	l.Warn("timeout", kvlog.WithStr("host", "b"))
+	l.Warn("timeout", kvlog.WithStr("host", "b"))
+	h.Close(context.Background())
+
+	exp := regexp.MustCompile(`^level=error msg=timeout attempt=0 host=a
+level=error msg=<timeout \(repeated 2 times over \d+(\.\d+)?m?s\)> attempt=0 host=a repeated=2
+level=error msg=timeout host=b
+level=warn msg=timeout host=b
+level=warn msg=<timeout \(repeated 1 time over \d+(\.\d+)?m?s\)> host=b repeated=1
+$`)
+	if !exp.MatchString(buf.String()) {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+}
+
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func TestDedupHandler_window(t *testing.T) {
+	var buf syncBuffer
+
+	l := kvlog.New(kvlog.NewDedupHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter), 50*time.Millisecond))
+
+	l.Logs("retry")
+	l.Logs("retry")
+	l.Logs("retry")
+
+	time.Sleep(100 * time.Millisecond)
+
+	l.Logs("retry")
+
+	exp := regexp.MustCompile(`^msg=retry
+msg=<retry \(repeated 2 times over \d+(\.\d+)?m?s\)> repeated=2
+msg=retry
+$`)
+	if !exp.MatchString(buf.String()) {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+}
+
+func TestDedupHandler_errorsAndUncomparableValues(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := kvlog.NewDedupHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter), 0, kvlog.KeyError, "s")
+	l := kvlog.New(h)
+
+	for i := 0; i < 3; i++ {
+		l.Error("failed", kvlog.WithErr(fmt.Errorf("connection refused")), kvlog.WithKV("s", structWithInterface{V: []int{1}}))
+	}
+	l.Error("failed", kvlog.WithErr(fmt.Errorf("timeout")), kvlog.WithKV("s", structWithInterface{V: []int{1}}))
+	l.Error("failed", kvlog.WithErr(fmt.Errorf("timeout")), kvlog.WithKV("s", structWithInterface{V: []int{2}}))
+	h.Close(context.Background())
+
+	exp := regexp.MustCompile(`^level=error msg=failed s=<{\[1\]}> err=<connection refused>
+level=error msg=<failed \(repeated 2 times over \d+(\.\d+)?m?s\)> s=<{\[1\]}> err=<connection refused> repeated=2
+level=error msg=failed s=<{\[1\]}> err=timeout
+level=error msg=failed s=<{\[2\]}> err=timeout
+$`)
+	if !exp.MatchString(buf.String()) {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+}
@@ -27,8 +27,9 @@
 //
 // Handlers can be synchronous as well as asynchronous. Synchronous Handlers execute the Formatter as well as
 // writing the output in the same goroutine that invoked the Logger. Asynchronous Handlers dispatch the log
-// event to a different goroutine via a channel. Thus, asynchronous Handlers must be closed before shutdown
-// in order to flush the channel and emit all log events.
+// event to a different goroutine via a queue. Thus, asynchronous Handlers must be closed before shutdown
+// in order to flush the queue and emit all log events. Calling Close on the root Logger closes all of its
+// Handlers.
 //
 // # Emitting Events
 //
@@ -36,6 +37,12 @@
 // calling its KV or delegating methods (such as Err or Dur). Eventually, submit the Event by invoking
 // Log or Logf. Anything passed to Log is under under the Event's "msg" key.
 //
+// # Levels
+//
+// Events may carry a Level by emitting them via one of the level methods Debug, Info, Warn or Error. A root
+// Logger created with NewWithOptions discards all events below the configured minimum level. Use Enabled to
+// check whether a level is enabled before building expensive pairs.
+//
 // # Deriving Loggers
 //
 // Loggers can be derived from other Loggers. This enables to configure a set of key-value-pairs to be added
@@ -51,10 +58,11 @@
 //
 // # Formatters
 //
-// The kvlog package comes with three Formatters out of the box:
+// The kvlog package comes with four Formatters out of the box:
 //   - JSONLFormatter formats events as JSON line values
 //   - TerminalFormatter formats events for output on a terminal which includes colorizing the event
 //   - KVFormatter formats events in the legacy KV-Format
+//   - SyslogFormatter formats events as syslog messages (RFC 5424 or RFC 3164)
 //
 // Custom formatters may be created by implementing the Formatter interface or using the FormatterFunc
 // convenience type.
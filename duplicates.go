@@ -0,0 +1,175 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import "strconv"
+
+// DuplicateKeyPolicy defines how a root Logger handles an Event containing multiple pairs with the same key.
+//
+// Pairs are added to an Event in the following order: the pairs passed to the log method, the message and
+// level, the pairs added by derived loggers (from the innermost one up to the root) and finally the pairs
+// added by hooks. Formatters output pairs in reverse order (see Event.EachPair), so a pair passed to a log
+// method is output after a pair with the same key added by a derived logger.
+//
+// Pairs with one of the reserved keys KeyMessage, KeyTime and KeyLevel are always resolved in favor of the
+// pair set by the Logger itself (such as the message passed to Logs or the time added by TimeHook), so these
+// cannot be overwritten by pairs passed to a log method, derived loggers or other hooks. Only top-level pairs
+// and pairs of the same group are compared.
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeysKeep keeps all pairs. This is the default.
+	DuplicateKeysKeep DuplicateKeyPolicy = iota
+	// DuplicateKeysLastWins keeps the pair output last, i.e. pairs passed to a log method win over pairs added
+	// by derived loggers or hooks.
+	DuplicateKeysLastWins
+	// DuplicateKeysFirstWins keeps the pair output first, i.e. pairs added by derived loggers or hooks win over
+	// pairs passed to a log method.
+	DuplicateKeysFirstWins
+	// DuplicateKeysSuffix keeps all pairs but appends a suffix (_2, _3, ...) to the keys of all pairs but the
+	// one output first.
+	DuplicateKeysSuffix
+)
+
+// isReservedKey reports whether pairs with key set by the Logger must not be replaced by other pairs with the
+// same key.
+func isReservedKey(key string) bool {
+	return key == KeyMessage || key == KeyLevel || key == KeyTime
+}
+
+// resolveDuplicateKeys applies policy to e.
+func (e *Event) resolveDuplicateKeys(policy DuplicateKeyPolicy) {
+	if policy == DuplicateKeysKeep {
+		return
+	}
+
+	e.len = resolveDuplicateKeys(e.pairs[:e.len], policy)
+}
+
+// resolveDuplicateKeys applies policy to pairs given in the order they have been added and returns the
+// number of pairs kept. Kept pairs are moved to the front of pairs preserving their order. Groups are
+// resolved recursively.
+func resolveDuplicateKeys(pairs []Pair, policy DuplicateKeyPolicy) int {
+	m := 0
+	for i := range pairs {
+		p := pairs[i]
+
+		if isReservedKey(p.Key) {
+			if l := loggerPairIndex(pairs, p.Key); l >= 0 {
+				if i != l {
+					if policy != DuplicateKeysSuffix {
+						continue
+					}
+					// The pair set by the Logger keeps the key, all others are numbered in output order.
+					n := countKey(pairs[i+1:], p.Key)
+					if l > i {
+						n--
+					}
+					p.Key += "_" + strconv.Itoa(n+2)
+				}
+
+				pairs[m] = p
+				m++
+				continue
+			}
+		}
+
+		switch policy {
+		case DuplicateKeysSuffix:
+			if n := countKey(pairs[i+1:], p.Key); n > 0 {
+				p.Key += "_" + strconv.Itoa(n+1)
+			}
+		case DuplicateKeysFirstWins:
+			if countKey(pairs[i+1:], p.Key) > 0 {
+				continue
+			}
+		case DuplicateKeysLastWins:
+			if countKey(pairs[:m], p.Key) > 0 {
+				// pairs[:m] holds the kept pairs, which include the first pair of each key.
+				continue
+			}
+		}
+
+		if p.kind == KindGroup {
+			p.Value = resolveGroupDuplicateKeys(p.Value.(Group), policy)
+		}
+
+		pairs[m] = p
+		m++
+	}
+
+	return m
+}
+
+// resolveGroupDuplicateKeys applies policy to g. As groups may be shared between events, g is left unchanged
+// and a new Group is returned if any pairs need to be changed.
+func resolveGroupDuplicateKeys(g Group, policy DuplicateKeyPolicy) Group {
+	if !hasDuplicateKeys(g) {
+		return g
+	}
+
+	// Groups store their pairs in priority order, so reverse them to get the order they have been added.
+	pairs := make([]Pair, len(g))
+	for i, p := range g {
+		pairs[len(g)-1-i] = p
+	}
+
+	n := resolveDuplicateKeys(pairs, policy)
+
+	r := make(Group, n)
+	for i := 0; i < n; i++ {
+		r[n-1-i] = pairs[i]
+	}
+
+	return r
+}
+
+// hasDuplicateKeys reports whether g or any nested group contains multiple pairs with the same key.
+func hasDuplicateKeys(g Group) bool {
+	for i, p := range g {
+		if countKey(g[i+1:], p.Key) > 0 {
+			return true
+		}
+		if p.kind == KindGroup && hasDuplicateKeys(p.Value.(Group)) {
+			return true
+		}
+	}
+	return false
+}
+
+// loggerPairIndex returns the index of the pair with key set by the Logger that has been added last or -1 if
+// pairs contains no such pair.
+func loggerPairIndex(pairs []Pair, key string) int {
+	for i := len(pairs) - 1; i >= 0; i-- {
+		if pairs[i].fromLogger && pairs[i].Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// countKey returns the number of pairs with key.
+func countKey(pairs []Pair, key string) int {
+	n := 0
+	for _, p := range pairs {
+		if p.Key == key {
+			n++
+		}
+	}
+	return n
+}
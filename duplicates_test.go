@@ -0,0 +1,120 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := map[kvlog.DuplicateKeyPolicy]string{
+		kvlog.DuplicateKeysKeep: `{"user":1,"level":"info","msg":"login","msg":"other","user":2}
+{"msg":"query","db":{"name":"users","name":"orders"}}
+`,
+		kvlog.DuplicateKeysLastWins: `{"level":"info","msg":"login","user":2}
+{"msg":"query","db":{"name":"orders"}}
+`,
+		kvlog.DuplicateKeysFirstWins: `{"user":1,"level":"info","msg":"login"}
+{"msg":"query","db":{"name":"users"}}
+`,
+		kvlog.DuplicateKeysSuffix: `{"user":1,"level":"info","msg":"login","msg_2":"other","user_2":2}
+{"msg":"query","db":{"name":"users","name_2":"orders"}}
+`,
+	}
+
+	for policy, exp := range tests {
+		var buf bytes.Buffer
+
+		l := kvlog.NewWithOptions(kvlog.Options{DuplicateKeys: policy},
+			kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+		l.Sub(kvlog.WithInt("user", 1)).Info("login", kvlog.WithInt("user", 2), kvlog.WithStr("msg", "other"))
+		l.SubGroup("db", kvlog.WithStr("name", "users")).Logs("query", kvlog.WithStr("name", "orders"))
+
+		if buf.String() != exp {
+			t.Errorf("%d: expected '%s' but got '%s'", policy, exp, buf.String())
+		}
+	}
+}
+
+func TestDuplicateKeys_sharedGroup(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.NewWithOptions(kvlog.Options{DuplicateKeys: kvlog.DuplicateKeysFirstWins},
+		kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter())).
+		Sub(kvlog.WithGroup("http", kvlog.WithStr("method", "GET"), kvlog.WithStr("method", "POST")))
+
+	l.Logs("first")
+	l.Logs("second")
+
+	exp := `{"http":{"method":"POST"},"msg":"first"}
+{"http":{"method":"POST"},"msg":"second"}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestDuplicateKeys_time(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.NewWithOptions(kvlog.Options{DuplicateKeys: kvlog.DuplicateKeysLastWins},
+		kvlog.NewSyncHandler(&buf, kvlog.KVFormatter)).
+		AddHook(kvlog.TimeHook)
+	now := time.Now()
+
+	l.Logs("msg", kvlog.WithTime(kvlog.KeyTime, time.Unix(0, 0).UTC()), kvlog.WithStr("foo", "bar"))
+
+	exp := fmt.Sprintf("time=%s msg=msg foo=bar\n", now.Format(time.RFC3339))
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestDuplicateKeys_reservedKeysFromSub(t *testing.T) {
+	tests := map[kvlog.DuplicateKeyPolicy]string{
+		kvlog.DuplicateKeysLastWins: `{"level":"error","msg":"real"}
+{"msg":"real"}
+`,
+		kvlog.DuplicateKeysFirstWins: `{"level":"error","msg":"real"}
+{"msg":"real"}
+`,
+		kvlog.DuplicateKeysSuffix: `{"level_2":"sub","msg_2":"sub","level":"error","msg":"real"}
+{"msg_2":"sub","msg":"real","msg_3":"call"}
+`,
+	}
+
+	for policy, exp := range tests {
+		var buf bytes.Buffer
+
+		l := kvlog.NewWithOptions(kvlog.Options{DuplicateKeys: policy},
+			kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+		l.Sub(kvlog.WithStr("level", "sub")).Sub(kvlog.WithStr("msg", "sub")).Error("real")
+		l.Sub(kvlog.WithStr("msg", "sub")).Logs("real", kvlog.WithStr("msg", "call"))
+
+		if buf.String() != exp {
+			t.Errorf("%d: expected '%s' but got '%s'", policy, exp, buf.String())
+		}
+	}
+}
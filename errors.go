@@ -0,0 +1,41 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"reflect"
+)
+
+// causes returns the errors directly wrapped by err. Both errors wrapping a single error (providing
+// Unwrap() error) and those wrapping multiple errors (providing Unwrap() []error) are supported.
+func causes(err error) []error {
+	switch u := err.(type) {
+	case interface{ Unwrap() []error }:
+		return u.Unwrap()
+	case interface{ Unwrap() error }:
+		if c := u.Unwrap(); c != nil {
+			return []error{c}
+		}
+	}
+	return nil
+}
+
+// errorType returns the name of err's Go type.
+func errorType(err error) string {
+	return reflect.TypeOf(err).String()
+}
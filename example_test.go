@@ -1,6 +1,7 @@
 package kvlog_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -32,13 +33,13 @@ func ExampleNewAsyncHandler() {
 	h := kvlog.NewAsyncHandler(os.Stdout, kvlog.JSONLFormatter())
 	logger := kvlog.New(h)
 	logger.Logs("test")
-	h.Close()
+	h.Close(context.Background())
 
 	// Output: {"msg":"test"}
 
 }
 
-func ExampleCustomHook() {
+func Example_customHook() {
 	extractTracingID := func() string {
 		// some real implementation here
 		return "1234"
@@ -53,3 +54,17 @@ func ExampleCustomHook() {
 
 	// Output: {"tracing_id":"1234","msg":"request"}
 }
+
+func ExampleHandlerFunc() {
+	// A custom Handler must not retain the event; copy everything needed before returning.
+	h := kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		msg, _ := e.Value(kvlog.KeyMessage)
+		fmt.Printf("received %q with %d pairs\n", msg, e.Len())
+		return nil
+	})
+
+	logger := kvlog.New(h)
+	logger.Logs("hello, world", kvlog.WithKV("foo", "bar"))
+
+	// Output: received "hello, world" with 2 pairs
+}
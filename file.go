@@ -0,0 +1,435 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat defines the format of the time stamp added to the names of rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryDelay defines the time to wait before rotating a file again after rotating failed.
+const rotateRetryDelay = time.Minute
+
+// FileOptions defines the options used to configure a File. The zero value disables rotation.
+type FileOptions struct {
+	// MaxSize defines the maximum size of the file in bytes. The file is rotated before a write would exceed
+	// this size. A single write is never split, so a file may exceed MaxSize if a single write does (i.e. a
+	// batch written by an async handler). If zero, the file is not rotated based on its size.
+	MaxSize int64
+
+	// RotateInterval defines the interval in which the file is rotated. The interval is measured from the
+	// time the file has been opened; the file is rotated with the first write after the interval has elapsed.
+	// If zero, the file is not rotated based on time.
+	RotateInterval time.Duration
+
+	// MaxBackups defines the maximum number of rotated files to keep. If zero, all rotated files are kept.
+	MaxBackups int
+
+	// MaxAge defines the maximum age of rotated files to keep. If zero, rotated files are kept regardless of
+	// their age.
+	MaxAge time.Duration
+
+	// Compress enables compressing rotated files using gzip. Compression happens in the background.
+	Compress bool
+
+	// Perm defines the permissions used when creating files. If zero, 0644 is used.
+	Perm os.FileMode
+
+	// OnError is invoked with every error that occurs when rotating the file as well as compressing or removing
+	// rotated files. If rotating fails, writing continues to the current file and rotating is retried after a
+	// minute. OnError is invoked from the goroutine writing to the file or a background goroutine and must be
+	// safe for concurrent use. Errors are ignored if nil.
+	OnError func(err error)
+}
+
+// File is an io.Writer writing to a file that is rotated based on its size and/or age. A rotated file is
+// renamed by adding the UTC time of rotation to its name (i.e. app.log becomes
+// app-2021-03-04T05-06-07.000.log). A sequence number is added if the name is already taken. Old rotated files
+// are removed according to MaxBackups and MaxAge.
+//
+// A File may be shared by several Handlers; all methods are safe for concurrent use. As Handlers write each
+// event with a single call to Write, a file is never rotated in the middle of an event.
+type File struct {
+	mtx      sync.Mutex
+	path     string
+	opts     FileOptions
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	retryAt  time.Time
+	now      func() time.Time
+	rename   func(oldpath, newpath string) error
+
+	// background guards compressing and removing rotated files.
+	background sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// OpenFile opens the file at path for appending, creating the file and its directory if necessary.
+func OpenFile(path string, opts FileOptions) (*File, error) {
+	if opts.Perm == 0 {
+		opts.Perm = 0644
+	}
+
+	f := &File{
+		path:   path,
+		opts:   opts,
+		now:    time.Now,
+		rename: os.Rename,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write writes p to the file, rotating the file before if necessary.
+func (f *File) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.needsRotation(len(p)) {
+		if err := f.rotate(); err != nil {
+			f.reportError(err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file regardless of its size and age.
+func (f *File) Rotate() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	return f.rotate()
+}
+
+// Reopen closes the file and opens the file at the same path again. Reopen is used to continue writing to a
+// new file after the file has been renamed by an external tool such as logrotate. The new file is opened
+// before the current one is closed; if opening fails, writing continues to the current file. Writes are
+// blocked while reopening, so every write goes either to the current or to the new file.
+func (f *File) Reopen() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	file, size, err := f.openFile()
+	if err != nil {
+		return err
+	}
+
+	old := f.file
+	f.file = file
+	f.size = size
+	f.openedAt = f.now()
+
+	return old.Close()
+}
+
+// Close closes the file and waits until all rotated files have been compressed and removed.
+func (f *File) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	f.wg.Wait()
+
+	return err
+}
+
+func (f *File) needsRotation(n int) bool {
+	if f.now().Before(f.retryAt) {
+		return false
+	}
+
+	if f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(n) > f.opts.MaxSize {
+		return true
+	}
+
+	return f.opts.RotateInterval > 0 && !f.now().Before(f.openedAt.Add(f.opts.RotateInterval))
+}
+
+// open opens the file at f.path. f.mtx must be held by the caller.
+func (f *File) open() error {
+	file, size, err := f.openFile()
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.size = size
+	f.openedAt = f.now()
+
+	return nil
+}
+
+// openFile opens the file at f.path for appending and returns it along with its current size.
+func (f *File) openFile() (*os.File, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, f.opts.Perm)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// rotate renames the current file, opens a new one and starts compressing and removing rotated files in the
+// background. f.mtx must be held by the caller.
+func (f *File) rotate() error {
+	// The file is reopened in any case, so an error closing it is not fatal.
+	closeErr := f.file.Close()
+	f.file = nil
+
+	backup := f.backupName(f.now())
+	if err := f.rename(f.path, backup); err != nil {
+		// Keep writing to the current file and retry rotating later.
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		f.retryAt = f.now().Add(rotateRetryDelay)
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		f.background.Lock()
+		defer f.background.Unlock()
+
+		if f.opts.Compress {
+			if err := compressFile(backup); err != nil {
+				f.reportError(err)
+			}
+		}
+		f.removeBackups()
+	}()
+
+	return closeErr
+}
+
+func (f *File) reportError(err error) {
+	if f.opts.OnError != nil {
+		f.opts.OnError(err)
+	}
+}
+
+// backupName returns an unused name for the file rotated at t. If the file has already been rotated within the
+// same millisecond, a sequence number is added to the time stamp (i.e. app-2021-03-04T05-06-07.000-1.log).
+func (f *File) backupName(t time.Time) string {
+	prefix, ext := f.backupPrefix()
+	ts := prefix + t.UTC().Format(backupTimeFormat)
+
+	name := ts + ext
+	for seq := 1; fileExists(name) || fileExists(name+".gz"); seq++ {
+		name = ts + "-" + strconv.Itoa(seq) + ext
+	}
+
+	return name
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// backupPrefix returns the prefix (including the directory) and extension of the names of rotated files.
+func (f *File) backupPrefix() (prefix, ext string) {
+	ext = filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-", ext
+}
+
+// backups returns the rotated files ordered by the time of rotation, newest first.
+func (f *File) backups() ([]backupFile, error) {
+	prefix, ext := f.backupPrefix()
+
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, entry := range entries {
+		name := filepath.Join(filepath.Dir(f.path), entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		ts := strings.TrimPrefix(name, prefix)
+		ts = strings.TrimSuffix(ts, ".gz")
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		}
+
+		ts = strings.TrimSuffix(ts, ext)
+		if len(ts) < len(backupTimeFormat) {
+			continue
+		}
+
+		t, err := time.Parse(backupTimeFormat, ts[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+
+		var seq int
+		if s := ts[len(backupTimeFormat):]; s != "" {
+			if seq, err = strconv.Atoi(strings.TrimPrefix(s, "-")); err != nil || s[0] != '-' || seq < 1 {
+				continue
+			}
+		}
+
+		backups = append(backups, backupFile{name: name, rotatedAt: t, seq: seq})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].rotatedAt.Equal(backups[j].rotatedAt) {
+			return backups[i].seq > backups[j].seq
+		}
+		return backups[i].rotatedAt.After(backups[j].rotatedAt)
+	})
+
+	return backups, nil
+}
+
+type backupFile struct {
+	name      string
+	rotatedAt time.Time
+	seq       int
+}
+
+// removeBackups removes rotated files exceeding MaxBackups or MaxAge.
+func (f *File) removeBackups() {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxAge <= 0 {
+		return
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		f.reportError(err)
+		return
+	}
+
+	cutoff := f.now().Add(-f.opts.MaxAge)
+
+	for i, b := range backups {
+		if (f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups) || (f.opts.MaxAge > 0 && b.rotatedAt.Before(cutoff)) {
+			if err := os.Remove(b.name); err != nil && !os.IsNotExist(err) {
+				f.reportError(err)
+			}
+		}
+	}
+}
+
+// compressFile compresses the file at name using gzip and removes it afterwards. On error, the uncompressed
+// file is kept.
+func compressFile(name string) (err error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(name + ".gz")
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+
+	if _, err = io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	in.Close()
+
+	if err = os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove compressed file: %w", err)
+	}
+
+	return nil
+}
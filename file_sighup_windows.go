@@ -0,0 +1,27 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build windows
+// +build windows
+
+package kvlog
+
+// ReopenOnSIGHUP does nothing as Windows provides no SIGHUP. It exists so that programs using ReopenOnSIGHUP
+// compile on all platforms. Call Reopen directly to reopen f. The returned function does nothing either.
+func ReopenOnSIGHUP(f *File, onError func(error)) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,554 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFile_rotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenFile(path, FileOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	for _, s := range []string{"first\n", "second\n", "third\n"} {
+		clock = clock.Add(time.Second)
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readFiles(t, filepath.Dir(path))
+	exp := map[string]string{
+		"app.log":                         "third\n",
+		"app-2021-03-04T05-06-09.000.log": "first\n",
+		"app-2021-03-04T05-06-10.000.log": "second\n",
+	}
+	assertFiles(t, exp, files)
+}
+
+func TestFile_rotatesWithinSameMillisecond(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+
+		// Uses the real clock; most rotations happen within the same millisecond.
+		f, err := OpenFile(filepath.Join(dir, "app.log"), FileOptions{MaxSize: 5, Compress: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for n := 0; n < 20; n++ {
+			if _, err := f.Write([]byte("message " + strconv.Itoa(n+10) + "\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		files := readFiles(t, dir)
+		if len(files) != 20 {
+			t.Errorf("expected 20 files but got %d", len(files))
+		}
+
+		var size int
+		for _, content := range files {
+			size += len(content)
+		}
+		if size != 220 {
+			t.Errorf("expected 220 bytes but got %d", size)
+		}
+	}
+}
+
+func TestFile_backupsOrderedBySequence(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{
+		"app-2021-03-04T05-06-07.000-2.log",
+		"app-2021-03-04T05-06-07.000.log",
+		"app-2021-03-04T05-06-08.000.log.gz",
+		"app-2021-03-04T05-06-07.000-10.log.gz",
+		"app-2021-03-04T05-06-07.000-x.log",
+	} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	f := &File{path: filepath.Join(dir, "app.log")}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"app-2021-03-04T05-06-08.000.log.gz",
+		"app-2021-03-04T05-06-07.000-10.log.gz",
+		"app-2021-03-04T05-06-07.000-2.log",
+		"app-2021-03-04T05-06-07.000.log",
+	}
+
+	var got []string
+	for _, b := range backups {
+		got = append(got, filepath.Base(b.name))
+	}
+
+	if strings.Join(got, " ") != strings.Join(exp, " ") {
+		t.Errorf("expected %v but got %v", exp, got)
+	}
+}
+
+func TestFile_rotatesByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenFile(path, FileOptions{RotateInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+	f.openedAt = clock
+
+	f.Write([]byte("first\n"))
+	clock = clock.Add(30 * time.Minute)
+	f.Write([]byte("second\n"))
+	clock = clock.Add(30 * time.Minute)
+	f.Write([]byte("third\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"app.log":                         "third\n",
+		"app-2021-03-04T06-00-00.000.log": "first\nsecond\n",
+	}
+	assertFiles(t, exp, readFiles(t, filepath.Dir(path)))
+}
+
+func TestFile_appendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+	f, err := OpenFile(path, FileOptions{MaxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("first\n"))
+	f.Close()
+
+	f, err = OpenFile(path, FileOptions{MaxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.size != 6 {
+		t.Errorf("expected size 6 but got %d", f.size)
+	}
+
+	f.Write([]byte("second\n"))
+
+	assertFiles(t, map[string]string{"app.log": "first\nsecond\n"}, readFiles(t, filepath.Dir(path)))
+}
+
+func TestFile_removesBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	// A file not created by rotation must be left alone.
+	os.WriteFile(filepath.Join(dir, "app-other.log"), []byte("other\n"), 0644)
+
+	f, err := OpenFile(path, FileOptions{MaxBackups: 2, MaxAge: 90 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	// The oldest backup is removed due to its age, the second oldest due to the number of backups.
+	for _, s := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		f.Write([]byte(s))
+		if err := f.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		f.wg.Wait()
+		clock = clock.Add(time.Hour)
+	}
+	clock = clock.Add(-time.Hour)
+	f.Write([]byte("5\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"app.log":                         "5\n",
+		"app-other.log":                   "other\n",
+		"app-2021-03-04T07-00-00.000.log": "3\n",
+		"app-2021-03-04T08-00-00.000.log": "4\n",
+	}
+	assertFiles(t, exp, readFiles(t, dir))
+}
+
+func TestFile_compressesBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	f, err := OpenFile(path, FileOptions{MaxBackups: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	for _, s := range []string{"1\n", "2\n"} {
+		f.Write([]byte(s))
+		if err := f.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		f.wg.Wait()
+		clock = clock.Add(time.Hour)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"app.log":                            "",
+		"app-2021-03-04T06-00-00.000.log.gz": "2\n",
+	}
+	assertFiles(t, exp, readFiles(t, dir))
+}
+
+func TestFile_multipleHandlers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenFile(path, FileOptions{MaxSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var i int64
+	var mtx sync.Mutex
+	f.now = func() time.Time {
+		mtx.Lock()
+		defer mtx.Unlock()
+		i++
+		return time.Unix(i, 0)
+	}
+
+	syncHandler := NewSyncHandler(f, KVFormatter)
+	async := NewAsyncHandler(f, KVFormatter)
+	l := New(syncHandler, async)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				l.Logs("message")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readFiles(t, filepath.Dir(path))
+	if len(files) < 2 {
+		t.Errorf("expected file to be rotated but got %d files", len(files))
+	}
+
+	lines := 0
+	for _, content := range files {
+		for _, line := range strings.SplitAfter(content, "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.HasSuffix(line, "msg=message\n") {
+				t.Errorf("expected complete event but got '%s'", line)
+			}
+			lines++
+		}
+	}
+
+	if lines != 400 {
+		t.Errorf("expected 400 events but got %d", lines)
+	}
+}
+
+func TestFile_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("first\n"))
+
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("second\n"))
+
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("third\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"app.log":   "third\n",
+		"app.log.1": "first\nsecond\n",
+	}
+	assertFiles(t, exp, readFiles(t, dir))
+}
+
+func TestFile_Reopen_asyncHandler(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New(NewAsyncHandler(f, KVFormatter))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; n < 500; n++ {
+			l.Logs("message")
+		}
+	}()
+
+	for n := 1; n <= 5; n++ {
+		time.Sleep(time.Millisecond)
+		if err := os.Rename(path, path+"."+strconv.Itoa(n)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Reopen(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	<-done
+
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readFiles(t, dir)
+	if len(files) != 6 {
+		t.Errorf("expected 6 files but got %d", len(files))
+	}
+
+	lines := 0
+	for _, content := range files {
+		lines += strings.Count(content, "msg=message\n")
+	}
+
+	if lines != 500 {
+		t.Errorf("expected 500 events but got %d", lines)
+	}
+}
+
+func TestFile_Reopen_failure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "app.log")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Replace the directory with a regular file so that opening fails.
+	if err := os.Rename(filepath.Dir(path), filepath.Join(dir, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "logs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Reopen(); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := f.Write([]byte("message\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFiles(t, map[string]string{"app.log": "message\n"}, readFiles(t, filepath.Join(dir, "old")))
+}
+
+func TestFile_rotateFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	var errs []error
+	f, err := OpenFile(path, FileOptions{MaxSize: 10, OnError: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	renameErr := errors.New("rename failed")
+	renames := 0
+	f.rename = func(string, string) error {
+		renames++
+		return renameErr
+	}
+
+	for _, s := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if renames != 1 || len(errs) != 1 || errs[0] != renameErr {
+		t.Errorf("expected a single failed rotation but got %d renames and errors %v", renames, errs)
+	}
+
+	clock = clock.Add(rotateRetryDelay)
+	f.rename = os.Rename
+
+	if _, err := f.Write([]byte("fourth\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"app.log":                         "fourth\n",
+		"app-2021-03-04T05-01-00.000.log": "first\nsecond\nthird\n",
+	}
+	assertFiles(t, exp, readFiles(t, dir))
+}
+
+func TestFile_writeAfterClose(t *testing.T) {
+	f, err := OpenFile(filepath.Join(t.TempDir(), "app.log"), FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := f.Write([]byte("message\n")); err != os.ErrClosed {
+		t.Errorf("expected '%v' but got '%v'", os.ErrClosed, err)
+	}
+}
+
+// readFiles reads all files in dir, decompressing gzipped files.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.HasSuffix(entry.Name(), ".gz") {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err = io.ReadAll(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		files[entry.Name()] = string(data)
+	}
+
+	return files
+}
+
+func assertFiles(t *testing.T, exp, got map[string]string) {
+	t.Helper()
+
+	names := make([]string, 0, len(got))
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(exp) != len(got) {
+		t.Errorf("expected %d files but got %v", len(exp), names)
+	}
+
+	for name, content := range exp {
+		c, ok := got[name]
+		if !ok {
+			t.Errorf("expected file %s but got %v", name, names)
+			continue
+		}
+		if c != content {
+			t.Errorf("expected %s to contain '%s' but got '%s'", name, content, c)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"context"
+	"reflect"
+)
+
+// Filter defines the interface for types that decide whether an Event should be delivered by a Handler.
+type Filter interface {
+	// Match reports whether e should be delivered.
+	Match(e *Event) bool
+}
+
+// FilterFunc is a convenience type used to implement a Filter as a simple function.
+type FilterFunc func(e *Event) bool
+
+// Match simply calls ff.
+func (ff FilterFunc) Match(e *Event) bool { return ff(e) }
+
+// HasKey creates a Filter matching all events that contain a Pair with key.
+func HasKey(key string) Filter {
+	return FilterFunc(func(e *Event) bool {
+		return e.index(key) >= 0
+	})
+}
+
+// KeyEquals creates a Filter matching all events that contain a Pair with key and a value equal to value.
+func KeyEquals(key string, value interface{}) Filter {
+	return FilterFunc(func(e *Event) bool {
+		v, ok := e.Value(key)
+		if !ok {
+			return false
+		}
+		return valuesEqual(v, value)
+	})
+}
+
+// MinLevel creates a Filter matching all events with a level greater than or equal to level. Events that carry
+// no level match as well; combine MinLevel with HasKey(KeyLevel) to reject those.
+func MinLevel(level Level) Filter {
+	return FilterFunc(func(e *Event) bool {
+		l, ok := e.Level()
+		return !ok || l >= level
+	})
+}
+
+// And creates a Filter matching all events that are matched by all of filters.
+func And(filters ...Filter) Filter {
+	return FilterFunc(func(e *Event) bool {
+		for _, f := range filters {
+			if !f.Match(e) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Or creates a Filter matching all events that are matched by at least one of filters.
+func Or(filters ...Filter) Filter {
+	return FilterFunc(func(e *Event) bool {
+		for _, f := range filters {
+			if f.Match(e) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Not creates a Filter matching all events not matched by f.
+func Not(f Filter) Filter {
+	return FilterFunc(func(e *Event) bool {
+		return !f.Match(e)
+	})
+}
+
+// valuesEqual compares a and b. Numbers are compared by value so that a Pair created with WithInt equals an
+// int given to KeyEquals.
+func valuesEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ka, kb := numberKind(va.Kind()), numberKind(vb.Kind()); ka != 0 && ka == kb {
+		switch ka {
+		case reflect.Int64:
+			return va.Int() == vb.Int()
+		case reflect.Uint64:
+			return va.Uint() == vb.Uint()
+		default:
+			return va.Float() == vb.Float()
+		}
+	}
+
+	// Comparing structs, arrays or interfaces with == panics if they contain values that are not comparable
+	// (such as a slice stored in an interface{} field), so only scalars are compared with ==.
+	if isScalarKind(va.Kind()) && isScalarKind(vb.Kind()) {
+		return a == b
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// isScalarKind reports whether values of kind k can be compared with == without panicking.
+func isScalarKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String, reflect.Ptr, reflect.Chan, reflect.UnsafePointer, reflect.Complex64,
+		reflect.Complex128:
+		return true
+	default:
+		return numberKind(k) != reflect.Invalid
+	}
+}
+
+// numberKind maps all signed integer kinds to reflect.Int64, all unsigned integer kinds to reflect.Uint64 and
+// all floating point kinds to reflect.Float64. It returns reflect.Invalid for all other kinds.
+func numberKind(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int64
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint64
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	default:
+		return reflect.Invalid
+	}
+}
+
+type filterHandler struct {
+	handler Handler
+	filter  Filter
+}
+
+// NewFilterHandler creates a Handler that delivers only those events to h that are matched by f. f is
+// evaluated before h formats the event, so rejected events are never formatted.
+func NewFilterHandler(h Handler, f Filter) Handler {
+	return &filterHandler{
+		handler: h,
+		filter:  f,
+	}
+}
+
+func (h *filterHandler) Flush(ctx context.Context) error {
+	return flushHandler(ctx, h.handler)
+}
+
+func (h *filterHandler) Close(ctx context.Context) error {
+	return h.handler.Close(ctx)
+}
+
+func (h *filterHandler) Handle(e *Event) error {
+	if !h.filter.Match(e) {
+		return nil
+	}
+	return h.handler.Handle(e)
+}
@@ -0,0 +1,113 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestNewFilterHandler(t *testing.T) {
+	var all, filtered bytes.Buffer
+
+	l := kvlog.New(
+		kvlog.NewSyncHandler(&all, kvlog.JSONLFormatter()),
+		kvlog.NewFilterHandler(kvlog.NewSyncHandler(&filtered, kvlog.JSONLFormatter()),
+			kvlog.Or(
+				kvlog.KeyEquals("user", "admin"),
+				kvlog.And(kvlog.HasKey(kvlog.KeyLevel), kvlog.MinLevel(kvlog.LevelWarn)),
+			),
+		),
+	)
+
+	l.Logs("1", kvlog.WithKV("user", "admin"))
+	l.Logs("2", kvlog.WithKV("user", "guest"))
+	l.Info("3")
+	l.Error("4")
+	l.Logs("5")
+
+	expAll := `{"msg":"1","user":"admin"}
+{"msg":"2","user":"guest"}
+{"level":"info","msg":"3"}
+{"level":"error","msg":"4"}
+{"msg":"5"}
+`
+	if all.String() != expAll {
+		t.Errorf("expected '%s' but got '%s'", expAll, all.String())
+	}
+
+	expFiltered := `{"msg":"1","user":"admin"}
+{"level":"error","msg":"4"}
+`
+	if filtered.String() != expFiltered {
+		t.Errorf("expected '%s' but got '%s'", expFiltered, filtered.String())
+	}
+}
+
+func TestNewFilterHandler_notFormatted(t *testing.T) {
+	var formatted int
+
+	f := kvlog.FormatterFunc(func(w io.Writer, e *kvlog.Event) error {
+		formatted++
+		return nil
+	})
+
+	l := kvlog.New(kvlog.NewFilterHandler(kvlog.NewSyncHandler(io.Discard, f), kvlog.Not(kvlog.HasKey("skip"))))
+
+	l.Logs("1", kvlog.WithKV("skip", true))
+	l.Logs("2", kvlog.WithKV("tags", []string{"a", "b"}))
+
+	if formatted != 1 {
+		t.Errorf("expected formatter to be invoked once but got %d", formatted)
+	}
+}
+
+func TestKeyEquals_uncomparable(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewFilterHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter),
+		kvlog.KeyEquals("tags", []string{"a"})))
+
+	l.Log(kvlog.WithKV("tags", []string{"a"}))
+	l.Log(kvlog.WithKV("tags", []string{"b"}))
+
+	if buf.String() != "tags=<[a]>\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+type structWithInterface struct {
+	V interface{}
+}
+
+func TestKeyEquals_structWithUncomparableField(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewFilterHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter),
+		kvlog.KeyEquals("s", structWithInterface{V: []int{1}})))
+
+	l.Log(kvlog.WithKV("s", structWithInterface{V: []int{1}}))
+	l.Log(kvlog.WithKV("s", structWithInterface{V: []int{2}}))
+
+	if buf.String() != "s=<{[1]}>\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
@@ -0,0 +1,97 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+// Group defines the value type of a Pair grouping other pairs. Pairs are given in descending priority order,
+// just like the pairs of an Event. Formatters render a Group as a nested structure (such as a JSON object) or
+// by joining the keys with a dot.
+type Group []Pair
+
+// WithGroup creates a Pair with key that groups pairs. The order of pairs is interpreted the same way as
+// pairs passed to Logger.Log.
+func WithGroup(key string, pairs ...*Pair) *Pair {
+	g := make(Group, len(pairs))
+	for i, p := range pairs {
+		g[len(pairs)-1-i] = *p
+		pairPool.Put(p)
+	}
+	return newPair(Pair{Key: key, kind: KindGroup, Value: g})
+}
+
+// EachPair applies f to each Pair in g in priority order (most important first). Lazy values are resolved
+// before being passed to f.
+func (g Group) EachPair(f func(Pair)) {
+	for _, p := range g {
+		f(resolved(p))
+	}
+}
+
+// eachFlatPair applies f to p or - if p holds a Group - to each of the group's pairs with the keys joined
+// by a dot. Nested groups are flattened recursively.
+func eachFlatPair(p Pair, f func(Pair)) {
+	if p.kind != KindGroup && p.kind != KindAny {
+		f(p)
+		return
+	}
+
+	g, ok := p.Value.(Group)
+	if !ok {
+		f(p)
+		return
+	}
+
+	g.EachPair(func(c Pair) {
+		c.Key = p.Key + "." + c.Key
+		eachFlatPair(c, f)
+	})
+}
+
+// group moves all pairs of e - except those added by the Logger itself, such as the message - into a Group
+// stored under name. The group pair becomes the least important pair of e. If no pairs are to be grouped,
+// e is left unchanged.
+func (e *Event) group(name string) {
+	n := 0
+	for i := 0; i < e.len; i++ {
+		if !e.pairs[i].fromLogger {
+			n++
+		}
+	}
+
+	if n == 0 {
+		return
+	}
+
+	g := make(Group, 0, n)
+	for i := e.len - 1; i >= 0; i-- {
+		if !e.pairs[i].fromLogger {
+			g = append(g, e.pairs[i])
+		}
+	}
+
+	m := 0
+	for i := 0; i < e.len; i++ {
+		if e.pairs[i].fromLogger {
+			e.pairs[m] = e.pairs[i]
+			m++
+		}
+	}
+
+	copy(e.pairs[1:m+1], e.pairs[0:m])
+	e.pairs[0] = Pair{Key: name, kind: KindGroup, Value: g}
+	e.len = m + 1
+}
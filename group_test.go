@@ -0,0 +1,93 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestWithGroup(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+	l.Logs("request",
+		kvlog.WithGroup("http",
+			kvlog.WithKV("status", 200),
+			kvlog.WithKV("method", "GET"),
+		),
+		kvlog.WithGroup("user", kvlog.WithKV("id", 17)),
+	)
+
+	exp := `{"msg":"request","user":{"id":17},"http":{"method":"GET","status":200}}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestLogger_SubGroup(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+	db := l.Sub(kvlog.WithKV("tracing_id", "1234")).
+		SubGroup("db", kvlog.WithKV("name", "users"))
+
+	db.Info("query", kvlog.WithKV("rows", 3))
+	db.SubGroup("tx").Logs("commit", kvlog.WithKV("id", 1))
+	db.SubGroup("empty").Logs("empty")
+
+	exp := `{"tracing_id":"1234","level":"info","msg":"query","db":{"name":"users","rows":3}}
+{"tracing_id":"1234","msg":"commit","db":{"name":"users","tx":{"id":1}}}
+{"tracing_id":"1234","msg":"empty","db":{"name":"users"}}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestLogger_SubGroup_reservedKeys(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+	l.SubGroup("http").Info("req", kvlog.WithKV("level", "x"), kvlog.WithKV("time", "y"), kvlog.WithKV("msg", "z"))
+
+	exp := `{"level":"info","msg":"req","http":{"msg":"z","time":"y","level":"x"}}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestWithGroup_KVFormatter(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter))
+
+	l.SubGroup("http").Logs("request", kvlog.WithKV("status", 200), kvlog.WithGroup("req", kvlog.WithKV("method", "GET")))
+
+	exp := "msg=request http.req.method=GET http.status=200\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
@@ -19,8 +19,12 @@ package kvlog
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -30,48 +34,185 @@ var (
 	// Defines the number of preallocated buffers in a pool of buffers.
 	AsyncHandlerPoolSize = 64
 
-	// Number of log events to buffer in an async handler's channel.
+	// Number of log events to buffer in an async handler's queue.
 	AsyncHandlerChannelSize = 1024
+
+	// Default interval used to report the number of events dropped by an async handler.
+	DefaultDropReportInterval = 10 * time.Second
+
+	// Default delay before retrying a failed write for the first time.
+	DefaultRetryBackoff = 10 * time.Millisecond
+
+	// Default maximum number of bytes an async handler writes with a single call to Write.
+	DefaultBatchMaxBytes = 64 * 1024
+)
+
+// ErrHandlerClosed is returned when an event is delivered to a Handler that has been closed.
+var ErrHandlerClosed = errors.New("kvlog: handler closed")
+
+// Flusher is implemented by Handlers buffering events before writing them.
+type Flusher interface {
+	// Flush writes all buffered events. It returns once all events have been written or ctx is done, in
+	// which case ctx's error is returned.
+	Flush(ctx context.Context) error
+}
+
+// OverflowPolicy defines how an asynchronous Handler behaves when its queue is full, i.e. when events are
+// emitted faster than they can be written.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the goroutine emitting an event until there is room in the queue. This is the
+	// default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the event being emitted.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest event waiting to be written to make room for the event being emitted.
+	OverflowDropOldest
+	// OverflowBlockTimeout blocks the goroutine emitting an event for at most HandlerOptions.OverflowTimeout
+	// and drops the event afterwards.
+	OverflowBlockTimeout
 )
 
+// HandlerOptions defines the options used to configure a Handler. The zero value provides the defaults used by
+// NewSyncHandler and NewAsyncHandler. The options handling overflows only apply to asynchronous Handlers.
+type HandlerOptions struct {
+	// OnError is invoked with every error that occurs when formatting or writing an event and that could not
+	// be recovered by retrying. It is invoked from the goroutine writing the event (which is a dedicated
+	// goroutine for asynchronous Handlers) and must be safe for concurrent use. Errors are ignored if nil.
+	OnError func(err error)
+
+	// Fallback defines a writer (such as os.Stderr) that receives all events that could not be written to the
+	// Handler's primary writer.
+	Fallback io.Writer
+
+	// MaxRetries defines how often a failed write is retried. Only transient errors (i.e. errors providing a
+	// Temporary method returning true) are retried.
+	MaxRetries int
+
+	// RetryBackoff defines the delay before the first retry. The delay is doubled for every further retry. If
+	// zero, DefaultRetryBackoff is used.
+	RetryBackoff time.Duration
+
+	// BatchMaxBytes defines the maximum number of bytes an asynchronous Handler coalesces into a single call
+	// to Write. A single event exceeding this size is written on its own. If zero, DefaultBatchMaxBytes is
+	// used.
+	BatchMaxBytes int
+
+	// BatchMaxEvents defines the maximum number of events an asynchronous Handler coalesces into a single
+	// call to Write. If zero, the number of events is not limited.
+	BatchMaxEvents int
+
+	// FlushInterval defines how long an asynchronous Handler waits for further events before writing a batch
+	// that has not reached one of the limits above. If zero, all queued events are written as soon as
+	// possible.
+	FlushInterval time.Duration
+
+	// Overflow defines the policy applied when the Handler's queue is full.
+	Overflow OverflowPolicy
+
+	// OverflowTimeout defines how long to block with OverflowBlockTimeout before dropping an event. If not
+	// positive, events are dropped immediately.
+	OverflowTimeout time.Duration
+
+	// NeverDropErrors marks events with LevelError or above to never be dropped due to an overflow. Such
+	// events block until there is room in the queue regardless of Overflow.
+	NeverDropErrors bool
+
+	// DropReportInterval defines the interval in which the number of dropped events is reported as an event
+	// of its own with key KeyDropped. If zero, DefaultDropReportInterval is used. If negative, dropped events
+	// are not reported.
+	DropReportInterval time.Duration
+}
+
 type syncHandler struct {
 	lock      sync.Mutex
-	out       io.Writer
+	out       *output
 	formatter Formatter
+	buf       bytes.Buffer
+	closed    bool
 }
 
 // NewSyncHandler creates a new Handler that works synchronously by writing log events formatted with f to o.
-// f works by directly writing bytes to o; no buffering is done between those.
+// Each event is formatted into a buffer and written to o with a single call to Write.
 func NewSyncHandler(o io.Writer, f Formatter) Handler {
+	return NewSyncHandlerWithOptions(o, f, HandlerOptions{})
+}
+
+// NewSyncHandlerWithOptions creates a new Handler like NewSyncHandler configured with opts. Retries are
+// performed in the goroutine emitting the event.
+func NewSyncHandlerWithOptions(o io.Writer, f Formatter, opts HandlerOptions) Handler {
 	return &syncHandler{
-		out:       o,
+		out:       &output{w: o, opts: opts},
 		formatter: f,
 	}
 }
 
-func (h *syncHandler) Close() {}
+// Flush flushes h's writer if it buffers data itself (i.e. it provides a Flush method like bufio.Writer).
+func (h *syncHandler) Flush(context.Context) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.closed {
+		return ErrHandlerClosed
+	}
+
+	return h.out.flush()
+}
+
+func (h *syncHandler) Close(context.Context) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	h.closed = true
+	return h.out.flush()
+}
 
-func (h *syncHandler) deliver(e *Event) {
+func (h *syncHandler) Handle(e *Event) error {
 	h.lock.Lock()
 	defer h.lock.Unlock()
-	h.formatter.Format(h.out, e)
+
+	if h.closed {
+		return ErrHandlerClosed
+	}
+
+	h.buf.Reset()
+	if err := h.formatter.Format(&h.buf, e); err != nil {
+		h.out.reportError(err)
+		return err
+	}
+
+	return h.out.write(h.buf.Bytes())
 }
 
 type asyncHandler struct {
+	// dropped is accessed atomically and is kept first to guarantee 64 bit alignment.
+	dropped      uint64
 	lock         sync.Mutex
 	formatter    Formatter
+	out          *output
+	opts         HandlerOptions
 	pool         *sync.Pool
-	bufferChan   chan *bytes.Buffer
+	queue        *bufferQueue
 	finishedChan chan struct{}
+	stopReport   chan struct{}
+	reportDone   chan struct{}
+	closeOnce    sync.Once
 }
 
 // NewAsyncHandler creates a new Handler that works asynchronously. f is applied on every event writing to a
-// bytes.Buffer. This happens in the same goroutine as emitting the log. The resulting bytes are them send
-// over a channel to a dedicated goroutine which copies the bytes onto o.
+// bytes.Buffer. This happens in the same goroutine as emitting the log. The resulting bytes are then queued
+// for a dedicated goroutine which copies the bytes onto o.
 func NewAsyncHandler(o io.Writer, f Formatter) Handler {
-	bufferChan := make(chan *bytes.Buffer, AsyncHandlerChannelSize)
-	finishedChan := make(chan struct{})
+	return NewAsyncHandlerWithOptions(o, f, HandlerOptions{})
+}
 
+// NewAsyncHandlerWithOptions creates a new Handler like NewAsyncHandler configured with opts.
+func NewAsyncHandlerWithOptions(o io.Writer, f Formatter, opts HandlerOptions) Handler {
 	pool := &sync.Pool{
 		New: func() interface{} {
 			return bytes.NewBuffer(make([]byte, 0, AsyncHandlerBufferSize))
@@ -82,43 +223,237 @@ func NewAsyncHandler(o io.Writer, f Formatter) Handler {
 		pool.Put(bytes.NewBuffer(make([]byte, 0, AsyncHandlerBufferSize)))
 	}
 
-	go func() {
-		defer close(finishedChan)
+	h := &asyncHandler{
+		formatter:    f,
+		out:          &output{w: o, opts: opts},
+		opts:         opts,
+		pool:         pool,
+		queue:        newBufferQueue(AsyncHandlerChannelSize),
+		finishedChan: make(chan struct{}),
+	}
+
+	go h.consume()
+
+	interval := opts.DropReportInterval
+	if interval == 0 {
+		interval = DefaultDropReportInterval
+	}
+
+	if opts.Overflow != OverflowBlock && interval > 0 {
+		h.stopReport = make(chan struct{})
+		h.reportDone = make(chan struct{})
+
+		go func() {
+			defer close(h.reportDone)
+
+			t := time.NewTicker(interval)
+			defer t.Stop()
+
+			for {
+				select {
+				case <-t.C:
+					h.reportDropped(h.stopReport)
+				case <-h.stopReport:
+					return
+				}
+			}
+		}()
+	}
+
+	return h
+}
+
+// consume writes all queued events to h's output until h's queue has been closed. Events are coalesced into
+// batches written with a single call to Write.
+func (h *asyncHandler) consume() {
+	defer close(h.finishedChan)
+
+	maxBytes := h.opts.BatchMaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultBatchMaxBytes
+	}
 
-		for buf := range bufferChan {
-			// TODO: Handle error
-			o.Write(buf.Bytes())
-			buf.Reset()
-			pool.Put(buf)
+	var batch bytes.Buffer
+	var events int
+
+	flush := func() {
+		if batch.Len() > 0 {
+			h.out.write(batch.Bytes())
+			batch.Reset()
+			events = 0
 		}
-	}()
+	}
 
-	return &asyncHandler{
-		formatter:    f,
-		bufferChan:   bufferChan,
-		finishedChan: finishedChan,
-		pool:         pool,
+	var items []queuedBuffer
+	var timer *time.Timer
+	var timeout <-chan time.Time
+
+	for {
+		var closed bool
+		items, closed = h.queue.take(items[:0], timeout)
+
+		for _, item := range items {
+			if item.flushed != nil {
+				flush()
+				h.out.flush()
+				close(item.flushed)
+				continue
+			}
+
+			if batch.Len() > 0 && batch.Len()+item.buf.Len() > maxBytes {
+				flush()
+			}
+
+			batch.Write(item.buf.Bytes())
+			events++
+			h.release(item.buf)
+
+			if batch.Len() >= maxBytes || (h.opts.BatchMaxEvents > 0 && events >= h.opts.BatchMaxEvents) {
+				flush()
+			}
+		}
+
+		// take returns no items only if the flush interval has elapsed or the queue has been closed.
+		if closed || len(items) == 0 || h.opts.FlushInterval <= 0 || batch.Len() == 0 {
+			flush()
+			if timer != nil {
+				timer.Stop()
+				timer, timeout = nil, nil
+			}
+			if closed {
+				h.out.flush()
+				return
+			}
+			continue
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(h.opts.FlushInterval)
+			timeout = timer.C
+		}
 	}
 }
 
-func (h *asyncHandler) Close() {
-	close(h.bufferChan)
-	<-h.finishedChan
+// Flush writes all events delivered to h before Flush has been called and waits until they have been
+// written.
+func (h *asyncHandler) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+
+	_, err := h.queue.push(queuedBuffer{flushed: flushed, keep: true}, OverflowBlock, 0, ctx.Done())
+	if err == errPushCanceled {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events and waits until all queued events have been written. If ctx is done before,
+// the remaining events are still written in the background.
+func (h *asyncHandler) Close(ctx context.Context) error {
+	h.closeOnce.Do(func() {
+		if h.stopReport != nil {
+			close(h.stopReport)
+			<-h.reportDone
+			h.reportDropped(ctx.Done())
+		}
+
+		h.queue.close()
+	})
+
+	select {
+	case <-h.finishedChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (h *asyncHandler) deliver(e *Event) {
+func (h *asyncHandler) Handle(e *Event) error {
+	buf := h.pool.Get().(*bytes.Buffer)
+
+	// The formatter may not be safe for concurrent use, but the buffer is queued without holding the lock, so
+	// a full queue does not block goroutines that could otherwise drop their events.
 	h.lock.Lock()
-	defer h.lock.Unlock()
+	err := h.formatter.Format(buf, e)
+	h.lock.Unlock()
+
+	if err != nil {
+		h.out.reportError(err)
+		h.release(buf)
+		return err
+	}
+
+	keep := false
+	if h.opts.NeverDropErrors {
+		if l, ok := e.Level(); ok && l >= LevelError {
+			keep = true
+		}
+	}
+
+	return h.enqueue(queuedBuffer{buf: buf, keep: keep}, h.opts.Overflow, nil)
+}
+
+// enqueue adds item to h's queue applying policy. Blocking is canceled when done is closed.
+func (h *asyncHandler) enqueue(item queuedBuffer, policy OverflowPolicy, done <-chan struct{}) error {
+	dropped, err := h.queue.push(item, policy, h.opts.OverflowTimeout, done)
+	if dropped != nil {
+		atomic.AddUint64(&h.dropped, 1)
+		h.release(dropped)
+	}
+	if err != nil {
+		h.release(item.buf)
+	}
+	return err
+}
+
+// reportDropped emits an event reporting the number of events dropped since the last report, if any. If
+// done is closed before the event could be queued, the number is kept for the next report.
+func (h *asyncHandler) reportDropped(done <-chan struct{}) {
+	n := atomic.SwapUint64(&h.dropped, 0)
+	if n == 0 {
+		return
+	}
+
+	e := newEvent()
+	e.add(Pair{Key: KeyDropped, kind: KindUint64, num: n})
+	e.add(Pair{Key: KeyMessage, kind: KindString, str: "events dropped"})
+	e.add(Pair{Key: KeyLevel, Value: LevelWarn})
+	e.add(timePair(KeyTime, time.Now()))
 
 	buf := h.pool.Get().(*bytes.Buffer)
-	h.formatter.Format(buf, e)
-	h.bufferChan <- buf
+
+	h.lock.Lock()
+	err := h.formatter.Format(buf, e)
+	h.lock.Unlock()
+
+	if err != nil {
+		h.release(buf)
+		return
+	}
+
+	// The report must neither be dropped nor cause other events to be dropped.
+	if h.enqueue(queuedBuffer{buf: buf, keep: true}, OverflowBlock, done) != nil {
+		atomic.AddUint64(&h.dropped, n)
+	}
+}
+
+func (h *asyncHandler) release(buf *bytes.Buffer) {
+	buf.Reset()
+	h.pool.Put(buf)
 }
 
 type noopHandler struct{}
 
-func (*noopHandler) Close()         {}
-func (*noopHandler) deliver(*Event) {}
+func (*noopHandler) Close(context.Context) error { return nil }
+func (*noopHandler) Handle(*Event) error         { return nil }
 
 // NoOpHandler creates a no-operation handler that simply discards every event. Use this handler to silence
 // logging output completely.
@@ -0,0 +1,414 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/halimath/kvlog"
+)
+
+// blockingWriter blocks the first call to Write until release is closed.
+type blockingWriter struct {
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+	buf     syncBuffer
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	return w.buf.Write(p)
+}
+
+// timePattern matches the time of events reporting dropped events.
+var timePattern = regexp.MustCompile(`time=\S+ `)
+
+func withChannelSize(t *testing.T, size int) {
+	s := kvlog.AsyncHandlerChannelSize
+	kvlog.AsyncHandlerChannelSize = size
+	t.Cleanup(func() { kvlog.AsyncHandlerChannelSize = s })
+}
+
+func TestAsyncHandler_overflow(t *testing.T) {
+	tests := map[string]struct {
+		opts kvlog.HandlerOptions
+		exp  string
+	}{
+		"drop newest": {
+			opts: kvlog.HandlerOptions{Overflow: kvlog.OverflowDropNewest},
+			exp:  "msg=e0\nmsg=e1\nmsg=e2\nlevel=warn msg=<events dropped> dropped=2\n",
+		},
+		"drop oldest": {
+			opts: kvlog.HandlerOptions{Overflow: kvlog.OverflowDropOldest},
+			exp:  "msg=e0\nmsg=e3\nmsg=e4\nlevel=warn msg=<events dropped> dropped=2\n",
+		},
+		"block with timeout": {
+			opts: kvlog.HandlerOptions{Overflow: kvlog.OverflowBlockTimeout, OverflowTimeout: time.Millisecond},
+			exp:  "msg=e0\nmsg=e1\nmsg=e2\nlevel=warn msg=<events dropped> dropped=2\n",
+		},
+		"no report": {
+			opts: kvlog.HandlerOptions{Overflow: kvlog.OverflowDropNewest, DropReportInterval: -1},
+			exp:  "msg=e0\nmsg=e1\nmsg=e2\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			withChannelSize(t, 2)
+
+			w := newBlockingWriter()
+			h := kvlog.NewAsyncHandlerWithOptions(w, kvlog.KVFormatter, test.opts)
+			l := kvlog.New(h)
+
+			l.Logs("e0")
+			<-w.started
+			l.Logs("e1")
+			l.Logs("e2")
+			l.Logs("e3")
+			l.Logs("e4")
+
+			close(w.release)
+			h.Close(context.Background())
+
+			if got := timePattern.ReplaceAllString(w.buf.String(), ""); got != test.exp {
+				t.Errorf("expected '%s' but got '%s'", test.exp, got)
+			}
+		})
+	}
+}
+
+func TestAsyncHandler_neverDropErrors(t *testing.T) {
+	withChannelSize(t, 2)
+
+	w := newBlockingWriter()
+	h := kvlog.NewAsyncHandlerWithOptions(w, kvlog.KVFormatter, kvlog.HandlerOptions{
+		Overflow:           kvlog.OverflowDropOldest,
+		NeverDropErrors:    true,
+		DropReportInterval: -1,
+	})
+	l := kvlog.New(h)
+
+	l.Info("e0")
+	<-w.started
+	l.Error("e1")
+	l.Info("e2")
+	l.Info("e3")
+
+	close(w.release)
+	h.Close(context.Background())
+
+	exp := "level=info msg=e0\nlevel=error msg=e1\nlevel=info msg=e3\n"
+	if w.buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, w.buf.String())
+	}
+}
+
+func TestAsyncHandler_dropReportInterval(t *testing.T) {
+	withChannelSize(t, 1)
+
+	w := newBlockingWriter()
+	h := kvlog.NewAsyncHandlerWithOptions(w, kvlog.KVFormatter, kvlog.HandlerOptions{
+		Overflow:           kvlog.OverflowDropNewest,
+		DropReportInterval: 10 * time.Millisecond,
+	})
+	defer h.Close(context.Background())
+
+	l := kvlog.New(h)
+
+	l.Logs("e0")
+	<-w.started
+	l.Logs("e1")
+	l.Logs("e2")
+	close(w.release)
+
+	time.Sleep(50 * time.Millisecond)
+
+	exp := "msg=e0\nmsg=e1\nlevel=warn msg=<events dropped> dropped=1\n"
+	if got := timePattern.ReplaceAllString(w.buf.String(), ""); got != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, got)
+	}
+}
+
+func TestAsyncHandler_closed(t *testing.T) {
+	var buf syncBuffer
+
+	h := kvlog.NewAsyncHandler(&buf, kvlog.KVFormatter)
+	h.Close(context.Background())
+
+	if err := h.Handle(&kvlog.Event{}); err != kvlog.ErrHandlerClosed {
+		t.Errorf("expected ErrHandlerClosed but got %v", err)
+	}
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+// failingWriter fails the first failures calls to Write with err.
+type failingWriter struct {
+	failures int
+	err      error
+	calls    int
+	buf      bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.calls <= w.failures {
+		return 0, w.err
+	}
+	return w.buf.Write(p)
+}
+
+func TestSyncHandler_retry(t *testing.T) {
+	w := &failingWriter{failures: 2, err: fmt.Errorf("wrapped: %w", temporaryError{})}
+	var errs []error
+
+	l := kvlog.New(kvlog.NewSyncHandlerWithOptions(w, kvlog.KVFormatter, kvlog.HandlerOptions{
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		OnError:      func(err error) { errs = append(errs, err) },
+	}))
+
+	l.Logs("retried")
+
+	if w.buf.String() != "msg=retried\n" {
+		t.Errorf("unexpected output: '%s'", w.buf.String())
+	}
+
+	if w.calls != 3 || len(errs) != 0 {
+		t.Errorf("expected 3 calls and no errors but got %d calls and %v", w.calls, errs)
+	}
+}
+
+func TestSyncHandler_fallback(t *testing.T) {
+	failure := errors.New("disk full")
+	w := &failingWriter{failures: 1, err: failure}
+	var fallback bytes.Buffer
+	var errs []error
+
+	l := kvlog.New(kvlog.NewSyncHandlerWithOptions(w, kvlog.KVFormatter, kvlog.HandlerOptions{
+		MaxRetries: 2,
+		Fallback:   &fallback,
+		OnError:    func(err error) { errs = append(errs, err) },
+	}))
+
+	l.Logs("first")
+	l.Logs("second")
+
+	if fallback.String() != "msg=first\n" || w.buf.String() != "msg=second\n" {
+		t.Errorf("unexpected output: '%s' / '%s'", fallback.String(), w.buf.String())
+	}
+
+	if w.calls != 2 || len(errs) != 1 || errs[0] != failure {
+		t.Errorf("expected no retries and one error but got %d calls and %v", w.calls, errs)
+	}
+}
+
+func TestSyncHandler_formatError(t *testing.T) {
+	failure := errors.New("format failed")
+	var errs []error
+
+	h := kvlog.NewSyncHandlerWithOptions(&bytes.Buffer{}, kvlog.FormatterFunc(func(io.Writer, *kvlog.Event) error {
+		return failure
+	}), kvlog.HandlerOptions{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	if err := h.Handle(&kvlog.Event{}); err != failure {
+		t.Errorf("expected format error but got %v", err)
+	}
+
+	if len(errs) != 1 || errs[0] != failure {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestAsyncHandler_errors(t *testing.T) {
+	failure := errors.New("closed pipe")
+	w := &failingWriter{failures: 1, err: failure}
+	var fallback syncBuffer
+	errs := make(chan error, 1)
+
+	h := kvlog.NewAsyncHandlerWithOptions(w, kvlog.KVFormatter, kvlog.HandlerOptions{
+		Fallback: &fallback,
+		OnError:  func(err error) { errs <- err },
+	})
+
+	kvlog.New(h).Logs("failed")
+	h.Close(context.Background())
+
+	if fallback.String() != "msg=failed\n" {
+		t.Errorf("unexpected fallback output: '%s'", fallback.String())
+	}
+
+	if err := <-errs; err != failure {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// recordingWriter records the bytes passed to each call to Write.
+type recordingWriter struct {
+	mtx    sync.Mutex
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (w *recordingWriter) Writes() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return append([]string(nil), w.writes...)
+}
+
+func TestAsyncHandler_batch(t *testing.T) {
+	var rec recordingWriter
+	w := newBlockingWriter()
+
+	h := kvlog.NewAsyncHandlerWithOptions(io.MultiWriter(w, &rec), kvlog.KVFormatter, kvlog.HandlerOptions{
+		BatchMaxEvents: 4,
+	})
+	l := kvlog.New(h)
+
+	l.Logs("e0")
+	<-w.started
+	for i := 1; i < 10; i++ {
+		l.Logf("e%d", i)
+	}
+	close(w.release)
+	h.Close(context.Background())
+
+	exp := []string{
+		"msg=e0\n",
+		"msg=e1\nmsg=e2\nmsg=e3\nmsg=e4\n",
+		"msg=e5\nmsg=e6\nmsg=e7\nmsg=e8\n",
+		"msg=e9\n",
+	}
+	if fmt.Sprintf("%q", rec.Writes()) != fmt.Sprintf("%q", exp) {
+		t.Errorf("expected %q but got %q", exp, rec.Writes())
+	}
+}
+
+func TestAsyncHandler_batchMaxBytes(t *testing.T) {
+	var rec recordingWriter
+	w := newBlockingWriter()
+
+	h := kvlog.NewAsyncHandlerWithOptions(io.MultiWriter(w, &rec), kvlog.KVFormatter, kvlog.HandlerOptions{
+		BatchMaxBytes: 15,
+	})
+	l := kvlog.New(h)
+
+	l.Logs("e0")
+	<-w.started
+	l.Logs("e1")
+	l.Logs("e2")
+	l.Logs("a long message")
+	close(w.release)
+	h.Close(context.Background())
+
+	exp := []string{
+		"msg=e0\n",
+		"msg=e1\nmsg=e2\n",
+		"msg=<a long message>\n",
+	}
+	if fmt.Sprintf("%q", rec.Writes()) != fmt.Sprintf("%q", exp) {
+		t.Errorf("expected %q but got %q", exp, rec.Writes())
+	}
+}
+
+func TestAsyncHandler_Flush(t *testing.T) {
+	var rec recordingWriter
+
+	h := kvlog.NewAsyncHandlerWithOptions(&rec, kvlog.KVFormatter, kvlog.HandlerOptions{
+		FlushInterval: time.Hour,
+	})
+	l := kvlog.New(h)
+
+	l.Logs("e0")
+	l.Logs("e1")
+
+	time.Sleep(10 * time.Millisecond)
+
+	if len(rec.Writes()) != 0 {
+		t.Errorf("expected no writes before flush but got %q", rec.Writes())
+	}
+
+	if err := h.(kvlog.Flusher).Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprintf("%q", rec.Writes()) != `["msg=e0\nmsg=e1\n"]` {
+		t.Errorf("unexpected writes: %q", rec.Writes())
+	}
+
+	l.Logs("e2")
+	h.Close(context.Background())
+
+	if len(rec.Writes()) != 2 {
+		t.Errorf("expected events to be written on close but got %q", rec.Writes())
+	}
+
+	if err := h.(kvlog.Flusher).Flush(context.Background()); err != kvlog.ErrHandlerClosed {
+		t.Errorf("expected ErrHandlerClosed but got %v", err)
+	}
+}
+
+func TestAsyncHandler_flushInterval(t *testing.T) {
+	var rec recordingWriter
+
+	h := kvlog.NewAsyncHandlerWithOptions(&rec, kvlog.KVFormatter, kvlog.HandlerOptions{
+		FlushInterval: 20 * time.Millisecond,
+	})
+	defer h.Close(context.Background())
+
+	l := kvlog.New(h)
+
+	l.Logs("e0")
+	l.Logs("e1")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if fmt.Sprintf("%q", rec.Writes()) != `["msg=e0\nmsg=e1\n"]` {
+		t.Errorf("unexpected writes: %q", rec.Writes())
+	}
+}
@@ -17,9 +17,115 @@
 
 package kvlog
 
-import "time"
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
 
-// TimeHook is a Hook that adds the current time as key KeyTime.
+// TimeHook is a Hook that adds the current time as key KeyTime. Events that already carry a time set by the
+// Logger (such as the time of a record emitted via a slog.Handler created with NewSlogHandler) are left
+// unchanged. Pairs with KeyTime passed to a log method do not prevent TimeHook from adding the time.
 var TimeHook = HookFunc(func(e *Event) {
-	e.AddPair(WithKV(KeyTime, time.Now()))
+	if hasLoggerTime(e) {
+		return
+	}
+	p := timePair(KeyTime, time.Now())
+	p.fromLogger = true
+	e.add(p)
 })
+
+// hasLoggerTime reports whether e contains a pair with KeyTime set by the Logger.
+func hasLoggerTime(e *Event) bool {
+	for i := 0; i < e.len; i++ {
+		if e.pairs[i].fromLogger && e.pairs[i].Key == KeyTime {
+			return true
+		}
+	}
+	return false
+}
+
+// Caller describes the source code location an event has been emitted from.
+type Caller struct {
+	File     string
+	Line     int
+	Function string
+}
+
+// String returns c formatted as file:line.
+func (c Caller) String() string {
+	return c.File + ":" + strconv.Itoa(c.Line)
+}
+
+// CallerHook is a Hook that adds the source code location an event has been emitted from as key KeyCaller.
+var CallerHook = NewCallerHook(0, false)
+
+// callerSkipPrefixes lists the prefixes of function names that are skipped when determining the caller. This
+// includes package kvlog as well as the standard library logging packages that can be bridged to kvlog.
+var callerSkipPrefixes = []string{
+	reflect.TypeOf(logger{}).PkgPath() + ".",
+	"log/slog.",
+	"log.",
+}
+
+// maxCallerDepth defines the maximum number of stack frames inspected when determining the caller.
+const maxCallerDepth = 32
+
+// NewCallerHook creates a Hook that adds the source code location an event has been emitted from as a Caller
+// with key KeyCaller. All stack frames from package kvlog (as well as from packages log and log/slog when
+// bridged to kvlog) are skipped, so the location is correct regardless of the log method being used or the
+// number of derived loggers. skip defines the number of additional frames to skip, which is useful when events
+// are emitted from a wrapper function. If withFunction is true, the name of the calling function is added as
+// key KeyFunction. Events that already contain a KeyCaller pair (such as events emitted by a writer created
+// with NewStdLogWriter) are left unchanged.
+func NewCallerHook(skip int, withFunction bool) Hook {
+	return HookFunc(func(e *Event) {
+		if e.index(KeyCaller) >= 0 {
+			return
+		}
+
+		c, ok := caller(skip)
+		if !ok {
+			return
+		}
+
+		e.add(Pair{Key: KeyCaller, Value: c})
+		if withFunction {
+			e.add(Pair{Key: KeyFunction, kind: KindString, str: c.Function})
+		}
+	})
+}
+
+// caller returns the first stack frame of the calling goroutine not belonging to package kvlog after skipping
+// skip additional frames.
+func caller(skip int) (Caller, bool) {
+	var pcs [maxCallerDepth]uintptr
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		f, more := frames.Next()
+
+		if !isSkippedFrame(f.Function) {
+			if skip == 0 {
+				return Caller{File: f.File, Line: f.Line, Function: f.Function}, true
+			}
+			skip--
+		}
+
+		if !more {
+			return Caller{}, false
+		}
+	}
+}
+
+func isSkippedFrame(function string) bool {
+	for _, p := range callerSkipPrefixes {
+		if strings.HasPrefix(function, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestCallerHook(t *testing.T) {
+	var callers []kvlog.Caller
+	var functions []string
+
+	h := kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		c, _ := e.Value(kvlog.KeyCaller)
+		callers = append(callers, c.(kvlog.Caller))
+		if f, ok := e.Value(kvlog.KeyFunction); ok {
+			functions = append(functions, f.(string))
+		}
+		return nil
+	})
+
+	l := kvlog.New(h).AddHook(kvlog.NewCallerHook(0, true))
+
+	_, _, line, _ := runtime.Caller(0)
+	l.Logs("logs")
+	l.Logf("logf %d", 1)
+	l.Sub(kvlog.WithKV("foo", "bar")).SubGroup("group").Info("sub")
+
+	for i, c := range callers {
+		if filepath.Base(c.File) != "hooks_test.go" || c.Line != line+i+1 {
+			t.Errorf("expected hooks_test.go:%d but got %s", line+i+1, c)
+		}
+	}
+
+	for _, f := range functions {
+		if f != "github.com/halimath/kvlog_test.TestCallerHook" {
+			t.Errorf("unexpected function: %s", f)
+		}
+	}
+
+	if len(callers) != 3 || len(functions) != 3 {
+		t.Errorf("expected 3 events but got %d", len(callers))
+	}
+}
+
+func TestCallerHook_skip(t *testing.T) {
+	var c kvlog.Caller
+
+	l := kvlog.New(kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		v, _ := e.Value(kvlog.KeyCaller)
+		c = v.(kvlog.Caller)
+		return nil
+	})).AddHook(kvlog.NewCallerHook(1, false))
+
+	logWrapper := func(msg string) {
+		l.Logs(msg)
+	}
+
+	_, _, line, _ := runtime.Caller(0)
+	logWrapper("wrapped")
+
+	if filepath.Base(c.File) != "hooks_test.go" || c.Line != line+1 {
+		t.Errorf("expected hooks_test.go:%d but got %s", line+1, c)
+	}
+}
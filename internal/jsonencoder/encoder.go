@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -81,7 +82,7 @@ func (w *Encoder) isNestedObject() bool {
 }
 
 func (w *Encoder) increaseNesting() {
-	if w.nestingStackPointer >= len(w.nestingStack) {
+	if w.nestingStackPointer+1 >= len(w.nestingStack) {
 		w.nestingStack = append(w.nestingStack, nestedStructure{})
 	}
 
@@ -134,6 +135,13 @@ func (w *Encoder) Int(i int64) *Encoder {
 	return w
 }
 
+// Uint outputs u formatted as a JSON number.
+func (w *Encoder) Uint(u uint64) *Encoder {
+	w.beforeValue()
+	w.buf = strconv.AppendUint(w.buf, u, 10)
+	return w
+}
+
 // Float outputs f formatted as a JSON number.
 func (w *Encoder) Float(f float64) *Encoder {
 	w.beforeValue()
@@ -141,6 +149,26 @@ func (w *Encoder) Float(f float64) *Encoder {
 	return w
 }
 
+// Time outputs t formatted according to layout as a JSON string. layout must not produce any characters that
+// require escaping.
+func (w *Encoder) Time(t time.Time, layout string) *Encoder {
+	w.beforeValue()
+	w.buf = append(w.buf, '"')
+	w.buf = t.AppendFormat(w.buf, layout)
+	w.buf = append(w.buf, '"')
+	return w
+}
+
+// Seconds outputs d as a JSON string containing the number of seconds with millisecond precision followed by
+// an s (i.e. "1.500s").
+func (w *Encoder) Seconds(d time.Duration) *Encoder {
+	w.beforeValue()
+	w.buf = append(w.buf, '"')
+	w.buf = strconv.AppendFloat(w.buf, d.Seconds(), 'f', 3, 64)
+	w.buf = append(w.buf, 's', '"')
+	return w
+}
+
 // Bool outputs bol formatted as a JSON boolean.
 func (w *Encoder) Bool(bol bool) *Encoder {
 	w.beforeValue()
@@ -155,6 +183,13 @@ func (w *Encoder) Null() *Encoder {
 	return w
 }
 
+// Raw outputs b without any modification. b must contain a single valid JSON value without any line breaks.
+func (w *Encoder) Raw(b []byte) *Encoder {
+	w.beforeValue()
+	w.buf = append(w.buf, b...)
+	return w
+}
+
 // StartObject starts a new JSON object.
 func (w *Encoder) StartObject() *Encoder {
 	w.beforeValue()
@@ -3,6 +3,7 @@ package jsonencoder
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriter_String(t *testing.T) {
@@ -75,6 +76,49 @@ func TestWriter_Null(t *testing.T) {
 	}
 }
 
+func TestWriter_Raw(t *testing.T) {
+	w := New()
+	w.StartArray()
+	w.Int(1)
+	w.Raw([]byte(`{"foo":"bar"}`))
+	w.EndArray()
+	act := strings.TrimSpace(w.String())
+
+	if act != `[1,{"foo":"bar"}]` {
+		t.Errorf(`expected '[1,{"foo":"bar"}]' got '%s'`, act)
+	}
+}
+
+func TestWriter_Uint(t *testing.T) {
+	w := New()
+	w.Uint(1 << 63)
+	act := strings.TrimSpace(w.String())
+
+	if act != "9223372036854775808" {
+		t.Errorf("expected '9223372036854775808' got '%s'", act)
+	}
+}
+
+func TestWriter_Time(t *testing.T) {
+	w := New()
+	w.Time(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), time.RFC3339)
+	act := strings.TrimSpace(w.String())
+
+	if act != `"2021-03-04T05:06:07Z"` {
+		t.Errorf(`expected '"2021-03-04T05:06:07Z"' got '%s'`, act)
+	}
+}
+
+func TestWriter_Seconds(t *testing.T) {
+	w := New()
+	w.Seconds(1500 * time.Millisecond)
+	act := strings.TrimSpace(w.String())
+
+	if act != `"1.500s"` {
+		t.Errorf(`expected '"1.500s"' got '%s'`, act)
+	}
+}
+
 func TestWriter_Float(t *testing.T) {
 	w := New()
 	w.Float(1.2345)
@@ -160,6 +204,22 @@ func TestWriter_ObjectWithMultipleKeys(t *testing.T) {
 	}
 }
 
+func TestWriter_DeepNesting(t *testing.T) {
+	w := New()
+	for i := 0; i < DefaultNestingDepth+2; i++ {
+		w.StartArray()
+	}
+	for i := 0; i < DefaultNestingDepth+2; i++ {
+		w.EndArray()
+	}
+	act := strings.TrimSpace(w.String())
+	exp := strings.Repeat("[", DefaultNestingDepth+2) + strings.Repeat("]", DefaultNestingDepth+2)
+
+	if act != exp {
+		t.Errorf("expected '%s' got '%s'", exp, act)
+	}
+}
+
 func TestWriter_InvalidKeyUsage(t *testing.T) {
 	type testCase func(*Encoder)
 	expectPanic := func(t *testing.T, tc testCase) {
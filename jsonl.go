@@ -18,15 +18,26 @@
 package kvlog
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/halimath/kvlog/internal/jsonencoder"
 )
 
+// JSONLMaxDepth defines the maximum nesting depth of slices, arrays, maps and structs rendered by a
+// JSONLFormatter. Values nested deeper are rendered as strings.
+var JSONLMaxDepth = 8
+
 type jsonlFormatter struct {
 	enc *jsonencoder.Encoder
+	raw bytes.Buffer
 }
 
 // JSONLFormatter creates a new Formatter that formats JSON lines.
@@ -41,49 +52,277 @@ func (f *jsonlFormatter) Format(w io.Writer, e *Event) error {
 
 	f.enc.StartObject()
 
-	e.EachPair(func(p Pair) {
-		f.enc.Key(p.Key)
-
-		switch x := p.Value.(type) {
-		case time.Time:
-			f.enc.Str(x.Format(time.RFC3339))
-		case time.Duration:
-			f.enc.Str(fmt.Sprintf("%.3fs", x.Seconds()))
-		case int:
-			f.enc.Int(int64(x))
-		case int8:
-			f.enc.Int(int64(x))
-		case int16:
-			f.enc.Int(int64(x))
-		case int32:
-			f.enc.Int(int64(x))
-		case int64:
-			f.enc.Int(x)
-		case uint:
-			f.enc.Int(int64(x))
-		case uint8:
-			f.enc.Int(int64(x))
-		case uint16:
-			f.enc.Int(int64(x))
-		case uint32:
-			f.enc.Int(int64(x))
-		case uint64:
-			f.enc.Int(int64(x))
-		case float32:
-			f.enc.Float(float64(x))
-		case float64:
-			f.enc.Float(x)
-		case string:
-			f.enc.Str(x)
-		default:
-			f.enc.Str(fmt.Sprintf("%s", x))
-		}
-	})
+	e.EachPair(f.encodePair)
 
 	f.enc.EndObject()
 
-	w.Write(f.enc.Bytes())
-	w.Write([]byte{'\n'})
+	_, err := w.Write(append(f.enc.Bytes(), '\n'))
+	return err
+}
+
+func (f *jsonlFormatter) encodePair(p Pair) {
+	f.enc.Key(p.Key)
+
+	switch p.Kind() {
+	case KindString:
+		f.enc.Str(p.Str())
+	case KindInt64:
+		f.enc.Int(p.Int64())
+	case KindUint64:
+		f.enc.Uint(p.Uint64())
+	case KindFloat64:
+		f.enc.Float(p.Float64())
+	case KindBool:
+		f.enc.Bool(p.Bool())
+	case KindTime:
+		f.enc.Time(p.Time(), time.RFC3339)
+	case KindDuration:
+		f.enc.Seconds(p.Duration())
+	default:
+		f.encodeValue(p.Value, 0)
+		f.encodeCausesOf(p)
+	}
+}
+
+// encodeCausesOf encodes the errors wrapped by an error stored in p as a separate pair with p's key followed
+// by KeyCausesSuffix. The error itself is always encoded as a string, so the type of p's value does not depend
+// on whether it wraps other errors.
+func (f *jsonlFormatter) encodeCausesOf(p Pair) {
+	err, ok := p.Value.(error)
+	if !ok || isNilPointer(err) {
+		return
+	}
+
+	if cs := causes(err); len(cs) > 0 {
+		f.enc.Key(p.Key + KeyCausesSuffix)
+		f.encodeCauses(cs, 0)
+	}
+}
+
+func (f *jsonlFormatter) encodeValue(v interface{}, depth int) {
+	switch x := v.(type) {
+	case nil:
+		f.enc.Null()
+	case time.Time:
+		f.enc.Time(x, time.RFC3339)
+	case time.Duration:
+		f.enc.Seconds(x)
+	case int:
+		f.enc.Int(int64(x))
+	case int8:
+		f.enc.Int(int64(x))
+	case int16:
+		f.enc.Int(int64(x))
+	case int32:
+		f.enc.Int(int64(x))
+	case int64:
+		f.enc.Int(x)
+	case uint:
+		f.enc.Uint(uint64(x))
+	case uint8:
+		f.enc.Uint(uint64(x))
+	case uint16:
+		f.enc.Uint(uint64(x))
+	case uint32:
+		f.enc.Uint(uint64(x))
+	case uint64:
+		f.enc.Uint(x)
+	case float32:
+		f.enc.Float(float64(x))
+	case float64:
+		f.enc.Float(x)
+	case string:
+		f.enc.Str(x)
+	case Level:
+		f.enc.Str(x.String())
+	case Group:
+		f.enc.StartObject()
+		x.EachPair(f.encodePair)
+		f.enc.EndObject()
+	case Stack:
+		f.enc.StartArray()
+		for _, fr := range x {
+			f.enc.StartObject()
+			f.enc.Key("func").Str(fr.Function)
+			f.enc.Key("file").Str(fr.File)
+			f.enc.Key("line").Int(int64(fr.Line))
+			f.enc.EndObject()
+		}
+		f.enc.EndArray()
+	case []byte:
+		f.enc.Str(string(x))
+	case bool:
+		f.enc.Bool(x)
+	case json.Marshaler:
+		if isNilPointer(x) {
+			f.enc.Null()
+		} else {
+			f.encodeJSONMarshaler(x)
+		}
+	case error:
+		if isNilPointer(x) {
+			f.enc.Null()
+		} else {
+			f.enc.Str(x.Error())
+		}
+	case encoding.TextMarshaler:
+		if isNilPointer(x) {
+			f.enc.Null()
+		} else if t, err := x.MarshalText(); err != nil {
+			f.enc.Str(err.Error())
+		} else {
+			f.enc.Str(string(t))
+		}
+	case fmt.Stringer:
+		if isNilPointer(x) {
+			f.enc.Null()
+		} else {
+			f.enc.Str(x.String())
+		}
+	default:
+		f.encodeReflect(reflect.ValueOf(x), depth)
+	}
+}
+
+// encodeCauses encodes cs as an array of JSON objects each containing the error's message and Go type. The
+// errors wrapped by each error are encoded the same way as an array under the key causes.
+func (f *jsonlFormatter) encodeCauses(cs []error, depth int) {
+	f.enc.StartArray()
+	for _, c := range cs {
+		if c == nil || isNilPointer(c) {
+			f.enc.Null()
+			continue
+		}
+
+		f.enc.StartObject()
+		f.enc.Key("msg").Str(c.Error())
+		f.enc.Key("type").Str(errorType(c))
+
+		if cs := causes(c); len(cs) > 0 && depth+1 < JSONLMaxDepth {
+			f.enc.Key("causes")
+			f.encodeCauses(cs, depth+1)
+		}
+
+		f.enc.EndObject()
+	}
+	f.enc.EndArray()
+}
+
+// encodeJSONMarshaler embeds the output of m. The output is compacted to keep the whole event on a single
+// line. If m fails or produces invalid JSON, the error message is rendered as a string.
+func (f *jsonlFormatter) encodeJSONMarshaler(m json.Marshaler) {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		f.enc.Str(err.Error())
+		return
+	}
+
+	f.raw.Reset()
+	if err := json.Compact(&f.raw, b); err != nil {
+		f.enc.Str(err.Error())
+		return
+	}
+
+	f.enc.Raw(f.raw.Bytes())
+}
+
+// encodeReflect encodes v using reflection. Slices and arrays are rendered as JSON arrays, maps with string
+// keys and structs as JSON objects. Elements are passed back to encodeValue so they get the same treatment as
+// top-level values.
+func (f *jsonlFormatter) encodeReflect(v reflect.Value, depth int) {
+	if !v.IsValid() {
+		f.enc.Null()
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			f.enc.Null()
+			return
+		}
+		f.encodeValue(v.Elem().Interface(), depth)
+		return
+
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			f.enc.Null()
+			return
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		f.enc.Bool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.enc.Int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f.enc.Uint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f.enc.Float(v.Float())
+	case reflect.String:
+		f.enc.Str(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if depth >= JSONLMaxDepth {
+			f.enc.Str(fmt.Sprintf("%v", v.Interface()))
+			return
+		}
 
-	return nil
+		f.enc.StartArray()
+		for i := 0; i < v.Len(); i++ {
+			f.encodeValue(v.Index(i).Interface(), depth+1)
+		}
+		f.enc.EndArray()
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || depth >= JSONLMaxDepth {
+			f.enc.Str(fmt.Sprintf("%v", v.Interface()))
+			return
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		f.enc.StartObject()
+		for _, k := range keys {
+			f.enc.Key(k.String())
+			f.encodeValue(v.MapIndex(k).Interface(), depth+1)
+		}
+		f.enc.EndObject()
+
+	case reflect.Struct:
+		if depth >= JSONLMaxDepth {
+			f.enc.Str(fmt.Sprintf("%v", v.Interface()))
+			return
+		}
+
+		t := v.Type()
+		f.enc.StartObject()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				// Unexported field
+				continue
+			}
+
+			name := field.Name
+			if tag, ok := field.Tag.Lookup("json"); ok {
+				tagName := strings.Split(tag, ",")[0]
+				if tagName == "-" {
+					continue
+				}
+				if tagName != "" {
+					name = tagName
+				}
+			}
+
+			f.enc.Key(name)
+			f.encodeValue(v.Field(i).Interface(), depth+1)
+		}
+		f.enc.EndObject()
+
+	default:
+		f.enc.Str(fmt.Sprintf("%v", v.Interface()))
+	}
 }
@@ -0,0 +1,155 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+type marshaler struct{}
+
+func (marshaler) MarshalJSON() ([]byte, error) { return []byte("{\n  \"foo\": [1, 2]\n}"), nil }
+
+type errorPtr struct{}
+
+func (*errorPtr) Error() string { return "error pointer" }
+
+func TestJSONLFormatter_types(t *testing.T) {
+	tab := []struct {
+		val interface{}
+		exp string
+	}{
+		{true, `true`},
+		{false, `false`},
+		{nil, `null`},
+		{errors.New("some error"), `"some error"`},
+		{(*errorPtr)(nil), `null`},
+		{stringer{}, `"stringer"`},
+		{marshaler{}, `{"foo":[1,2]}`},
+		{net.IPv4(127, 0, 0, 1), `"127.0.0.1"`},
+		{[]interface{}{true, nil, stringer{}}, `[true,null,"stringer"]`},
+	}
+
+	for _, tc := range tab {
+		evt := newEvent()
+		evt.AddPair(WithKV("v", tc.val))
+
+		var buf bytes.Buffer
+		if err := JSONLFormatter().Format(&buf, evt); err != nil {
+			t.Errorf("failed to format %#v: %s", tc.val, err)
+			continue
+		}
+
+		exp := `{"v":` + tc.exp + "}\n"
+		if buf.String() != exp {
+			t.Errorf("failed to format %#v: expected '%s' but got '%s'", tc.val, exp, buf.String())
+		}
+	}
+}
+
+func TestJSONLFormatter_composite(t *testing.T) {
+	type address struct {
+		City    string `json:"city"`
+		Zip     string `json:"-"`
+		Country string
+		secret  string
+	}
+
+	type user struct {
+		Name    string   `json:"name,omitempty"`
+		Tags    []string `json:"tags"`
+		Address *address `json:"address"`
+	}
+
+	tab := map[string]interface{}{
+		`["a","b"]`:                        []string{"a", "b"},
+		`[1,2,3]`:                          [3]int{1, 2, 3},
+		`[]`:                               []int{},
+		`null`:                             []int(nil),
+		`{"a":1,"b":2}`:                    map[string]int{"b": 2, "a": 1},
+		`"map[1:a]"`:                       map[int]string{1: "a"},
+		`{"city":"Berlin","Country":"DE"}`: address{City: "Berlin", Zip: "10115", Country: "DE", secret: "x"},
+		`{"name":"foo","tags":["x"],"address":null}`: &user{Name: "foo", Tags: []string{"x"}},
+		`"hello"`:     []byte("hello"),
+		`[[1],[2,3]]`: [][]int{{1}, {2, 3}},
+	}
+
+	for exp, val := range tab {
+		evt := newEvent()
+		evt.AddPair(WithKV("v", val))
+
+		var buf bytes.Buffer
+		if err := JSONLFormatter().Format(&buf, evt); err != nil {
+			t.Errorf("failed to format %#v: %s", val, err)
+			continue
+		}
+
+		exp = `{"v":` + exp + "}\n"
+		if buf.String() != exp {
+			t.Errorf("failed to format %#v: expected '%s' but got '%s'", val, exp, buf.String())
+		}
+	}
+}
+
+func TestJSONLFormatter_maxDepth(t *testing.T) {
+	defer func(d int) { JSONLMaxDepth = d }(JSONLMaxDepth)
+	JSONLMaxDepth = 2
+
+	evt := newEvent()
+	evt.AddPair(WithKV("v", [][][]int{{{1, 2}}}))
+
+	var buf bytes.Buffer
+	if err := JSONLFormatter().Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"v":[["[1 2]"]]}` + "\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestJSONLFormatter_errorChain(t *testing.T) {
+	inner := errors.New("inner")
+	err := fmt.Errorf("outer: %w", multiError{inner, fmt.Errorf("other: %w", inner)})
+
+	evt := newEvent()
+	evt.AddPair(WithErr(err))
+
+	var buf bytes.Buffer
+	if err := JSONLFormatter().Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"err":"outer: multiple errors","err_causes":[` +
+		`{"msg":"multiple errors","type":"kvlog.multiError","causes":[` +
+		`{"msg":"inner","type":"*errors.errorString"},` +
+		`{"msg":"other: inner","type":"*fmt.wrapError","causes":[{"msg":"inner","type":"*errors.errorString"}]}` +
+		`]}]}` + "\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
@@ -18,8 +18,12 @@
 package kvlog
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -31,11 +35,13 @@ func formatMessageAsKV(w io.Writer, e *Event) error {
 	var pairWritten bool
 
 	e.EachPair(func(p Pair) {
-		if pairWritten {
-			fmt.Fprint(w, " ")
-		}
-		formatPair(w, p)
-		pairWritten = true
+		eachFlatPair(p, func(p Pair) {
+			if pairWritten {
+				fmt.Fprint(w, " ")
+			}
+			formatPair(w, p)
+			pairWritten = true
+		})
 	})
 
 	_, err := w.Write([]byte("\n"))
@@ -51,7 +57,30 @@ func formatPair(w io.Writer, p Pair) (err error) {
 }
 
 func formatValue(w io.Writer, p Pair) (err error) {
-	switch x := p.Value.(type) {
+	switch p.Kind() {
+	case KindString:
+		err = formatStringValue(w, p.Str())
+	case KindInt64:
+		_, err = fmt.Fprintf(w, "%d", p.Int64())
+	case KindUint64:
+		_, err = fmt.Fprintf(w, "%d", p.Uint64())
+	case KindFloat64:
+		_, err = fmt.Fprintf(w, "%.3f", p.Float64())
+	case KindBool:
+		_, err = fmt.Fprintf(w, "%t", p.Bool())
+	case KindTime:
+		_, err = w.Write([]byte(p.Time().Format(time.RFC3339)))
+	case KindDuration:
+		_, err = fmt.Fprintf(w, "%.3fs", p.Duration().Seconds())
+	default:
+		err = formatAnyValue(w, p.Value)
+	}
+
+	return
+}
+
+func formatAnyValue(w io.Writer, v interface{}) (err error) {
+	switch x := v.(type) {
 	case time.Time:
 		_, err = w.Write([]byte(x.Format(time.RFC3339)))
 	case time.Duration:
@@ -62,13 +91,84 @@ func formatValue(w io.Writer, p Pair) (err error) {
 		_, err = fmt.Fprintf(w, "%.3f", x)
 	case string:
 		err = formatStringValue(w, x)
+	case bool:
+		_, err = fmt.Fprintf(w, "%t", x)
+	case nil:
+		_, err = w.Write([]byte("null"))
+	case Level:
+		_, err = w.Write([]byte(x.String()))
 	default:
-		_, err = fmt.Fprintf(w, "<%v>", x)
+		if s, ok := textValue(x); ok {
+			err = formatStringValue(w, s)
+		} else {
+			_, err = fmt.Fprintf(w, "<%v>", x)
+		}
 	}
 
 	return
 }
 
+// textValue returns the textual representation of values that provide one. This includes json.Marshalers
+// (rendered as compact JSON), errors, encoding.TextMarshalers and fmt.Stringers (checked in that order).
+// Nil pointers are rendered as "null". The boolean result reports whether v provides a textual representation.
+func textValue(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case json.Marshaler:
+		if isNilPointer(x) {
+			return "null", true
+		}
+		b, err := x.MarshalJSON()
+		if err != nil {
+			return err.Error(), true
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err != nil {
+			return err.Error(), true
+		}
+		return buf.String(), true
+	case error:
+		if isNilPointer(x) {
+			return "null", true
+		}
+		return x.Error(), true
+	case encoding.TextMarshaler:
+		if isNilPointer(x) {
+			return "null", true
+		}
+		b, err := x.MarshalText()
+		if err != nil {
+			return err.Error(), true
+		}
+		return string(b), true
+	case fmt.Stringer:
+		if isNilPointer(x) {
+			return "null", true
+		}
+		return x.String(), true
+	}
+
+	return "", false
+}
+
+// messageOf returns the textual representation of p's value.
+func messageOf(p Pair) string {
+	if p.Kind() == KindString {
+		return p.Str()
+	}
+
+	v := p.Any()
+	if s, ok := textValue(v); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// isNilPointer reports whether v holds a nil pointer. Calling methods on such values usually panics.
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
 func formatStringValue(w io.Writer, val string) (err error) {
 	if strings.ContainsAny(val, "<> =\t\n\r") {
 		_, err = fmt.Fprintf(w, "<%s>", val)
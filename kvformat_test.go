@@ -19,6 +19,7 @@ package kvlog
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -48,6 +49,11 @@ func TestFormatPair(t *testing.T) {
 		{Key: "foo", Value: "Hello world"}:   "foo=<Hello world>",
 		{Key: "foo", Value: ts}:              fmt.Sprintf("foo=%s", ts.Format(time.RFC3339)),
 		{Key: "foo", Value: 2 * time.Second}: "foo=2.000s",
+		{Key: "foo", Value: true}:            "foo=true",
+		{Key: "foo", Value: nil}:             "foo=null",
+		{Key: "foo", Value: errors.New("x")}: "foo=x",
+		{Key: "foo", Value: marshaler{}}:     `foo={"foo":[1,2]}`,
+		{Key: "foo", Value: stringer{}}:      "foo=stringer",
 	}
 
 	for p, exp := range tab {
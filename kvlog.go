@@ -18,11 +18,12 @@
 package kvlog
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 var (
@@ -32,12 +33,36 @@ var (
 	// The default key used to identify an event's error.
 	KeyError = "err"
 
+	// The default suffix appended to the key of an error to identify the errors it wraps.
+	KeyCausesSuffix = "_causes"
+
 	// The default key used to identify an event's message.
 	KeyMessage = "msg"
 
 	// The default key used to identify an event's duration value.
 	KeyDuration = "dur"
 
+	// The default key used to identify an event's level.
+	KeyLevel = "level"
+
+	// The default key used to identify the source code location an event has been emitted from.
+	KeyCaller = "caller"
+
+	// The default key used to identify the function an event has been emitted from.
+	KeyFunction = "func"
+
+	// The default key used to identify an event's stack trace.
+	KeyStack = "stack"
+
+	// The default key used to identify the number of events suppressed by a sampling handler.
+	KeySuppressed = "suppressed"
+
+	// The default key used to identify the number of repetitions reported by a deduplicating handler.
+	KeyRepeated = "repeated"
+
+	// The default key used to identify the number of events dropped by an async handler.
+	KeyDropped = "dropped"
+
 	// The default size Events created from an Event pool.
 	DefaultEventSize = 16
 
@@ -45,87 +70,129 @@ var (
 	InitialEventPoolSize = 128
 )
 
-// Pair defines a single key-value pair as part of a logging event.
-type Pair struct {
-	Key   string
-	Value interface{}
+// Event defines the type for a single logging event. key-value Pairs are given in descending priority order.
+type Event struct {
+	pairs []Pair
+	len   int
+	refs  int32
+	pool  *sync.Pool
 }
 
-var (
-	pairPool sync.Pool
-)
-
-const (
-	pairPoolInitialSize = 128
-)
+// Len returns the number of Pairs contained in e.
+func (e *Event) Len() int {
+	return e.len
+}
 
-func init() {
-	pairPool = sync.Pool{
-		New: func() interface{} {
-			return &Pair{}
-		},
+// EachPair applies f to each Pair in e in priority order (most important first). Lazy values are resolved
+// before being passed to f.
+func (e *Event) EachPair(f func(Pair)) {
+	for i := e.len - 1; i >= 0; i-- {
+		e.resolve(i)
+		f(e.pairs[i])
 	}
+}
 
-	for i := 0; i < pairPoolInitialSize; i++ {
-		pairPool.Put(&Pair{})
+// Value returns the value of the most important Pair with the given key. The boolean result reports whether
+// such a Pair exists. See Pair.Any for how values of scalar Kinds are returned.
+func (e *Event) Value(key string) (interface{}, bool) {
+	i := e.index(key)
+	if i < 0 {
+		return nil, false
 	}
+	e.resolve(i)
+	return e.pairs[i].Any(), true
 }
 
-// WithKV creates a new Pair to be added to either an Event or a Logger. Pairs are pulled from a pool and are
-// returned once the Event has been created.
-func WithKV(key string, value interface{}) *Pair {
-	p := pairPool.Get().(*Pair)
-	p.Key = key
-	p.Value = value
-	return p
+// index returns the index of the most important Pair with key or -1 if no such Pair exists.
+func (e *Event) index(key string) int {
+	for i := e.len - 1; i >= 0; i-- {
+		if e.pairs[i].Key == key {
+			return i
+		}
+	}
+	return -1
 }
 
-// WithErr creates a Pair with KeyError and err.
-func WithErr(err error) *Pair {
-	return WithKV(KeyError, err)
+// resolve resolves any lazy value of the pair at index i and stores the result in e, so every Valuer gets
+// resolved only once per Event. Pairs without lazy values are never written, so resolving an Event that has
+// been resolved before is safe for concurrent use.
+func (e *Event) resolve(i int) {
+	p := &e.pairs[i]
+	if p.kind == KindLazy || (p.kind == KindGroup && needsResolving(p.Value.(Group))) {
+		*p = resolved(*p)
+	}
 }
 
-// WithDur creates a Pair with KeyDuration and d.
-func WithDur(d time.Duration) *Pair {
-	return WithKV(KeyDuration, d)
+// Pairs returns a copy of e's pairs in priority order (most important first). Lazy values are resolved. Use
+// EachPair to iterate the pairs without allocating.
+func (e *Event) Pairs() []Pair {
+	pairs := make([]Pair, 0, e.len)
+	e.EachPair(func(p Pair) {
+		pairs = append(pairs, p)
+	})
+	return pairs
 }
 
-// Pairs defines a map of key-value-pairs to be added to an Event.
-type Pairs map[string]interface{}
+// Clone returns a copy of e with all lazy values resolved. The copy is not pooled and may be kept for any
+// duration. Pair values are not copied, so values referencing mutable data share that data with e.
+func (e *Event) Clone() *Event {
+	c := &Event{
+		pairs: make([]Pair, e.len),
+		len:   e.len,
+		refs:  1,
+	}
 
-// WithPairs adds all key-value pairs from p to e and returns e.
-func WithPairs(p Pairs) []*Pair {
-	pairs := make([]*Pair, 0, len(p))
-	for k, v := range p {
-		pairs = append(pairs, WithKV(k, v))
+	for i := 0; i < e.len; i++ {
+		e.resolve(i)
+		c.pairs[i] = e.pairs[i]
 	}
-	return pairs
+
+	return c
 }
 
-// Event defines the type for a single logging event. key-value Pairs are given in descending priority order.
-type Event struct {
-	pairs []Pair
-	len   int
+// Retain marks e as being used beyond the call to Handler.Handle, i.e. by a Handler processing events
+// asynchronously. e is not put back into its pool until Release has been called once for every call to
+// Retain. All lazy values are resolved, so e may be read from any goroutine afterwards. Retain must be called
+// before Handle returns and e must not be modified after being retained.
+func (e *Event) Retain() {
+	for i := 0; i < e.len; i++ {
+		e.resolve(i)
+	}
+	atomic.AddInt32(&e.refs, 1)
 }
 
-// Len returns the number of Pairs contained in e.
-func (e *Event) Len() int {
-	return e.len
+// Release releases e after a call to Retain. Once e has been released as often as it has been retained, e is
+// put back into its pool and must no longer be used.
+func (e *Event) Release() {
+	refs := atomic.AddInt32(&e.refs, -1)
+	if refs < 0 {
+		panic("kvlog: Event released more often than retained")
+	}
+	if refs == 0 && e.pool != nil {
+		e.pool.Put(e)
+	}
 }
 
-// EachPair applies f to each Pair in e in priority order (most important first).
-func (e *Event) EachPair(f func(Pair)) {
-	for i := e.len - 1; i >= 0; i-- {
-		f(e.pairs[i])
+// Level returns the Level stored under KeyLevel. The boolean result reports whether e carries a level at all.
+func (e *Event) Level() (Level, bool) {
+	v, ok := e.Value(KeyLevel)
+	if !ok {
+		return 0, false
 	}
+	l, ok := v.(Level)
+	return l, ok
 }
 
+// AddPair adds p to e. p becomes the most important Pair.
 func (e *Event) AddPair(p *Pair) {
+	e.add(*p)
+}
+
+func (e *Event) add(p Pair) {
 	if e.len < len(e.pairs) {
-		e.pairs[e.len].Key = p.Key
-		e.pairs[e.len].Value = p.Value
+		e.pairs[e.len] = p
 	} else {
-		e.pairs = append(e.pairs, Pair{Key: p.Key, Value: p.Value})
+		e.pairs = append(e.pairs, p)
 	}
 
 	e.len++
@@ -168,8 +235,49 @@ type Logger interface {
 	//
 	Logf(format string, args ...interface{})
 
+	// Debug works like Logs but adds a KeyLevel pair with LevelDebug. The event is discarded if LevelDebug is
+	// not enabled.
+	Debug(msg string, pairs ...*Pair)
+
+	// Info works like Logs but adds a KeyLevel pair with LevelInfo. The event is discarded if LevelInfo is
+	// not enabled.
+	Info(msg string, pairs ...*Pair)
+
+	// Warn works like Logs but adds a KeyLevel pair with LevelWarn. The event is discarded if LevelWarn is
+	// not enabled.
+	Warn(msg string, pairs ...*Pair)
+
+	// Error works like Logs but adds a KeyLevel pair with LevelError. The event is discarded if LevelError is
+	// not enabled.
+	Error(msg string, pairs ...*Pair)
+
+	// Enabled reports whether events with the given level are emitted by this logger. Use Enabled to guard
+	// the construction of expensive pairs.
+	//
+	// Example
+	//
+	//   if l.Enabled(kvlog.LevelDebug) {
+	//       l.Debug("request", kvlog.WithKV("body", dumpBody(r)))
+	//   }
+	//
+	Enabled(level Level) bool
+
 	// Sub creates a sub-logger using pairs for every event.
 	Sub(pairs ...*Pair) Logger
+
+	// SubGroup creates a sub-logger that works like one created with Sub but nests all pairs - pairs given
+	// to SubGroup as well as those passed to the log methods - under a Group named name. The message, level
+	// and time pairs are not nested.
+	SubGroup(name string, pairs ...*Pair) Logger
+
+	// Flush flushes all Handlers of the root Logger that implement Flusher. It returns once all events emitted
+	// before have been written or ctx is done, in which case ctx's error is returned.
+	Flush(ctx context.Context) error
+
+	// Close closes all Handlers of the root Logger. All Handlers share ctx to bound the time spent delivering
+	// pending events. Events emitted after Close has been called are discarded. The first error returned from
+	// any Handler is returned.
+	Close(ctx context.Context) error
 }
 
 // Formatter defines the interface implemented by all event formatters.
@@ -187,13 +295,36 @@ func (ff FormatterFunc) Format(w io.Writer, e *Event) error {
 	return ff(w, e)
 }
 
-// A Handler is used to deliver events to a given sink.
+// A Handler is used to deliver events to a given sink. Handlers are passed to New and receive every event
+// emitted via the root Logger or any Logger derived from it. Custom sinks are created by implementing this
+// interface or using the HandlerFunc convenience type.
+//
+// The *Event passed to Handle is owned by the Logger. It is pulled from a pool before any pairs are added and
+// put back into the pool once all Handlers have returned. A Handler must not use e or modify its pairs beyond
+// the call to Handle. A Handler processing events asynchronously either calls e.Clone to create a copy or
+// calls e.Retain before Handle returns and e.Release once it is done with e.
+//
+// Handle is invoked from the goroutine emitting the event. As Loggers may be used concurrently, Handle must
+// be safe for concurrent use.
 type Handler interface {
-	Close()
-	deliver(*Event)
-	// TODO: Add filter
+	// Handle handles e. An error returned from Handle does not affect other Handlers; the Logger discards it.
+	Handle(e *Event) error
+
+	// Close closes the Handler, delivering all pending events and releasing all resources. Close returns once
+	// all pending events have been delivered or ctx is done, in which case ctx's error is returned. Events
+	// handled after Close has been called are discarded. Calling Close more than once has no further effect.
+	Close(ctx context.Context) error
 }
 
+// HandlerFunc is a convenience type used to implement a Handler as a simple function. Close is a no-op.
+type HandlerFunc func(e *Event) error
+
+// Handle simply calls hf.
+func (hf HandlerFunc) Handle(e *Event) error { return hf(e) }
+
+// Close does nothing.
+func (HandlerFunc) Close(context.Context) error { return nil }
+
 func newEvent() *Event {
 	return &Event{
 		pairs: make([]Pair, DefaultEventSize),
@@ -201,47 +332,133 @@ func newEvent() *Event {
 	}
 }
 
+// Options defines the options used to configure a root Logger. The zero value provides the defaults used by
+// New.
+type Options struct {
+	// MinLevel defines the minimum level of events emitted with one of the level methods (such as Debug or
+	// Info). Events with a lower level are discarded. Events emitted via Log, Logs or Logf carry no level and
+	// are always emitted.
+	MinLevel Level
+
+	// DuplicateKeys defines how events containing multiple pairs with the same key are handled before being
+	// passed to the handlers. The default is to keep all pairs.
+	DuplicateKeys DuplicateKeyPolicy
+}
+
 // New creates a new root Logger. It sends the events to all given handlers.
 func New(handler ...Handler) Logger {
-	eventPool := &sync.Pool{
-		New: func() interface{} {
-			return newEvent()
-		},
+	return NewWithOptions(Options{}, handler...)
+}
+
+// NewWithOptions creates a new root Logger configured with opts. It sends the events to all given handlers.
+func NewWithOptions(opts Options, handler ...Handler) Logger {
+	eventPool := &sync.Pool{}
+	eventPool.New = func() interface{} {
+		e := newEvent()
+		e.pool = eventPool
+		return e
 	}
 
 	for i := 0; i < InitialEventPoolSize; i++ {
-		eventPool.Put(newEvent())
+		eventPool.Put(eventPool.New())
 	}
 
-	l := &logger{}
+	l := &logger{
+		minLevel: opts.MinLevel,
+		handlers: &handlerSet{handlers: handler},
+	}
 
 	l.newEventFunc = func() *Event {
 		e := eventPool.Get().(*Event)
 		e.len = 0
+		e.refs = 1
 		return e
 	}
 
 	l.deliverFunc = func(e *Event) {
+		if l.handlers.isClosed() {
+			e.Release()
+			return
+		}
+
 		for _, h := range l.hooks {
 			h.ApplyHook(e)
 		}
 
+		e.resolveDuplicateKeys(opts.DuplicateKeys)
+
 		for _, h := range handler {
-			h.deliver(e)
+			h.Handle(e)
 		}
 
-		eventPool.Put(e)
+		e.Release()
 	}
 
 	return l
 }
 
+// handlerSet holds the Handlers of a root Logger. It is shared by all Loggers derived from the root Logger.
+type handlerSet struct {
+	closed   int32
+	handlers []Handler
+}
+
+func (s *handlerSet) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) != 0
+}
+
+func (s *handlerSet) flush(ctx context.Context) error {
+	if s.isClosed() {
+		return nil
+	}
+
+	var err error
+	for _, h := range s.handlers {
+		if e := flushHandler(ctx, h); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *handlerSet) close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+
+	var err error
+	for _, h := range s.handlers {
+		if e := h.Close(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// flushHandler flushes h if h implements Flusher.
+func flushHandler(ctx context.Context, h Handler) error {
+	if f, ok := h.(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
 type logger struct {
 	hooks        []Hook
+	minLevel     Level
+	handlers     *handlerSet
 	deliverFunc  func(e *Event)
 	newEventFunc func() *Event
 }
 
+func (l *logger) Flush(ctx context.Context) error {
+	return l.handlers.flush(ctx)
+}
+
+func (l *logger) Close(ctx context.Context) error {
+	return l.handlers.close(ctx)
+}
+
 func (l *logger) AddHook(h Hook) Logger {
 	l.hooks = append(l.hooks, h)
 
@@ -249,27 +466,29 @@ func (l *logger) AddHook(h Hook) Logger {
 }
 
 func (l *logger) Log(pairs ...*Pair) {
+	l.emit(pairs)
+}
+
+func (l *logger) Logs(msg string, pairs ...*Pair) {
+	l.emit(pairs, Pair{Key: KeyMessage, kind: KindString, str: msg, fromLogger: true})
+}
+
+// emit creates an Event from pairs followed by extra and delivers it. pairs are put back into the pool.
+func (l *logger) emit(pairs []*Pair, extra ...Pair) {
 	evt := l.newEventFunc()
 	for _, p := range pairs {
 		evt.AddPair(p)
 		pairPool.Put(p)
 	}
-	l.deliverFunc(evt)
-}
-
-func (l *logger) Logs(msg string, pairs ...*Pair) {
-	if len(pairs) == 0 {
-		l.Log(WithKV(KeyMessage, msg))
-		return
+	for _, p := range extra {
+		evt.add(p)
 	}
-
-	pairs = append(pairs, WithKV(KeyMessage, msg))
-	l.Log(pairs...)
+	l.deliverFunc(evt)
 }
 
 func (l *logger) Logf(format string, args ...interface{}) {
 	formatArgs := make([]interface{}, 0, len(args))
-	pairs := make([]*Pair, 0, len(args)+1)
+	pairs := make([]*Pair, 0, len(args))
 
 	for _, arg := range args {
 		if p, ok := arg.(*Pair); ok {
@@ -279,12 +498,52 @@ func (l *logger) Logf(format string, args ...interface{}) {
 		}
 	}
 
-	pairs = append(pairs, WithKV(KeyMessage, fmt.Sprintf(format, formatArgs...)))
+	l.Logs(fmt.Sprintf(format, formatArgs...), pairs...)
+}
+
+func (l *logger) Debug(msg string, pairs ...*Pair) {
+	l.logLevel(LevelDebug, msg, pairs)
+}
+
+func (l *logger) Info(msg string, pairs ...*Pair) {
+	l.logLevel(LevelInfo, msg, pairs)
+}
+
+func (l *logger) Warn(msg string, pairs ...*Pair) {
+	l.logLevel(LevelWarn, msg, pairs)
+}
 
-	l.Log(pairs...)
+func (l *logger) Error(msg string, pairs ...*Pair) {
+	l.logLevel(LevelError, msg, pairs)
+}
+
+func (l *logger) logLevel(level Level, msg string, pairs []*Pair) {
+	if !l.Enabled(level) {
+		for _, p := range pairs {
+			pairPool.Put(p)
+		}
+		return
+	}
+
+	l.emit(pairs,
+		Pair{Key: KeyMessage, kind: KindString, str: msg, fromLogger: true},
+		Pair{Key: KeyLevel, Value: level, fromLogger: true},
+	)
+}
+
+func (l *logger) Enabled(level Level) bool {
+	return level >= l.minLevel
 }
 
 func (l *logger) Sub(pairs ...*Pair) Logger {
+	return l.sub("", pairs)
+}
+
+func (l *logger) SubGroup(name string, pairs ...*Pair) Logger {
+	return l.sub(name, pairs)
+}
+
+func (l *logger) sub(group string, pairs []*Pair) Logger {
 	h := HookFunc(func(e *Event) {
 		for _, p := range pairs {
 			e.AddPair(p)
@@ -293,6 +552,8 @@ func (l *logger) Sub(pairs ...*Pair) Logger {
 
 	sub := &logger{
 		hooks:        []Hook{h},
+		minLevel:     l.minLevel,
+		handlers:     l.handlers,
 		newEventFunc: l.newEventFunc,
 	}
 
@@ -300,6 +561,9 @@ func (l *logger) Sub(pairs ...*Pair) Logger {
 		for _, h := range sub.hooks {
 			h.ApplyHook(e)
 		}
+		if group != "" {
+			e.group(group)
+		}
 		l.deliverFunc(e)
 	}
 
@@ -337,9 +601,19 @@ func (l *noOpLogger) AddHook(Hook) Logger {
 func (*noOpLogger) Log(pairs ...*Pair)                      {}
 func (*noOpLogger) Logs(msg string, pairs ...*Pair)         {}
 func (*noOpLogger) Logf(format string, args ...interface{}) {}
+func (*noOpLogger) Debug(msg string, pairs ...*Pair)        {}
+func (*noOpLogger) Info(msg string, pairs ...*Pair)         {}
+func (*noOpLogger) Warn(msg string, pairs ...*Pair)         {}
+func (*noOpLogger) Error(msg string, pairs ...*Pair)        {}
+func (*noOpLogger) Enabled(level Level) bool                { return false }
 func (l *noOpLogger) Sub(pairs ...*Pair) Logger {
 	return l
 }
+func (l *noOpLogger) SubGroup(name string, pairs ...*Pair) Logger {
+	return l
+}
+func (*noOpLogger) Flush(context.Context) error { return nil }
+func (*noOpLogger) Close(context.Context) error { return nil }
 
 var noOpLoggerValue = &noOpLogger{}
 
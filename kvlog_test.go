@@ -18,7 +18,9 @@
 package kvlog_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -106,6 +108,23 @@ func TestLogger_withTimeHook(t *testing.T) {
 	}
 }
 
+func TestLogger_withTimeHook_timePair(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter())).
+		AddHook(kvlog.TimeHook)
+	now := time.Now()
+
+	l.Logs("hello", kvlog.WithTime(kvlog.KeyTime, time.Unix(0, 0).UTC()))
+
+	exp := fmt.Sprintf(`{"time":"%s","msg":"hello","time":"1970-01-01T00:00:00Z"}
+`, now.Format(time.RFC3339))
+
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
 func TestLogger_concurrentTest(t *testing.T) {
 	var buf bytes.Buffer
 
@@ -137,3 +156,234 @@ func TestLogger_concurrentTest(t *testing.T) {
 	}
 
 }
+
+func TestLogger_levels(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.NewWithOptions(kvlog.Options{MinLevel: kvlog.LevelInfo},
+		kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+
+	l.Debug("debug")
+	l.Info("info", kvlog.WithKV("foo", "bar"))
+	l.Sub(kvlog.WithKV("tracing_id", "1234")).Warn("warn")
+	l.Error("error")
+	l.Logs("no level")
+
+	exp := `{"level":"info","msg":"info","foo":"bar"}
+{"tracing_id":"1234","level":"warn","msg":"warn"}
+{"level":"error","msg":"error"}
+{"msg":"no level"}
+`
+
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestLogger_Enabled(t *testing.T) {
+	l := kvlog.NewWithOptions(kvlog.Options{MinLevel: kvlog.LevelWarn}, kvlog.NoOpHandler())
+	sub := l.Sub(kvlog.WithKV("foo", "bar"))
+
+	tab := map[kvlog.Level]bool{
+		kvlog.LevelDebug: false,
+		kvlog.LevelInfo:  false,
+		kvlog.LevelWarn:  true,
+		kvlog.LevelError: true,
+	}
+
+	for level, exp := range tab {
+		if got := l.Enabled(level); got != exp {
+			t.Errorf("%s: expected root logger to return %v but got %v", level, exp, got)
+		}
+		if got := sub.Enabled(level); got != exp {
+			t.Errorf("%s: expected sub logger to return %v but got %v", level, exp, got)
+		}
+	}
+
+	if kvlog.NoOpLogger().Enabled(kvlog.LevelError) {
+		t.Errorf("expected NoOpLogger to disable all levels")
+	}
+}
+
+type recordingHandler struct {
+	messages []string
+}
+
+func (h *recordingHandler) Handle(e *kvlog.Event) error {
+	msg, _ := e.Value(kvlog.KeyMessage)
+	h.messages = append(h.messages, fmt.Sprintf("%v", msg))
+	return fmt.Errorf("ignored")
+}
+
+func (h *recordingHandler) Close(context.Context) error { return nil }
+
+func TestLogger_customHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := &recordingHandler{}
+
+	l := kvlog.New(h, kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+	l.Logs("hello")
+	l.Sub(kvlog.WithKV("foo", "bar")).Logs("world")
+
+	if strings.Join(h.messages, ",") != "hello,world" {
+		t.Errorf("unexpected messages: %v", h.messages)
+	}
+
+	if buf.String() != "{\"msg\":\"hello\"}\n{\"foo\":\"bar\",\"msg\":\"world\"}\n" {
+		t.Errorf("error from custom handler affected other handlers: %q", buf.String())
+	}
+}
+
+func TestEvent_Retain(t *testing.T) {
+	events := make(chan *kvlog.Event, 10)
+	var resolved int
+
+	l := kvlog.New(kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		e.Retain()
+		events <- e
+		return nil
+	}))
+
+	for i := 0; i < 10; i++ {
+		l.Logs("event", kvlog.WithInt("i", i), kvlog.WithLazy("lazy", func() interface{} {
+			resolved++
+			return "value"
+		}))
+	}
+	close(events)
+
+	if resolved != 10 {
+		t.Errorf("expected lazy values to be resolved on retain but got %d", resolved)
+	}
+
+	i := 0
+	for e := range events {
+		v, _ := e.Value("i")
+		if v != int64(i) {
+			t.Errorf("expected %d but got %v", i, v)
+		}
+		e.Release()
+		i++
+	}
+
+	if resolved != 10 {
+		t.Errorf("expected lazy values to be resolved once but got %d", resolved)
+	}
+}
+
+func TestEvent_Release_tooOften(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+
+	var retained *kvlog.Event
+	kvlog.New(kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		retained = e
+		return nil
+	})).Logs("event")
+
+	retained.Release()
+}
+
+func TestEvent_Clone(t *testing.T) {
+	var clone *kvlog.Event
+
+	l := kvlog.New(kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		if clone == nil {
+			clone = e.Clone()
+		}
+		return nil
+	}))
+
+	l.Info("event", kvlog.WithStr("foo", "bar"), kvlog.WithLazy("lazy", func() interface{} { return 1 }))
+	l.Info("other")
+
+	var keys []string
+	for _, p := range clone.Pairs() {
+		keys = append(keys, fmt.Sprintf("%s=%v", p.Key, p.Any()))
+	}
+
+	if strings.Join(keys, ",") != "level=info,msg=event,lazy=1,foo=bar" {
+		t.Errorf("unexpected pairs: %v", keys)
+	}
+}
+
+func TestLogger_Close(t *testing.T) {
+	var buf syncBuffer
+
+	l := kvlog.New(kvlog.NewAsyncHandler(&buf, kvlog.KVFormatter))
+	sub := l.Sub(kvlog.WithKV("foo", "bar"))
+
+	l.Logs("before")
+	if err := sub.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	l.Logs("after")
+	sub.Logs("after")
+
+	if buf.String() != "msg=before\n" {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+
+	if err := l.Close(context.Background()); err != nil {
+		t.Errorf("expected closing twice to succeed but got %v", err)
+	}
+}
+
+func TestLogger_Close_deadline(t *testing.T) {
+	w := newBlockingWriter()
+	l := kvlog.New(kvlog.NewAsyncHandler(w, kvlog.KVFormatter))
+
+	l.Logs("blocked")
+	<-w.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded but got %v", err)
+	}
+
+	close(w.release)
+}
+
+func TestLogger_Flush(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	l := kvlog.New(kvlog.NewSyncHandler(w, kvlog.KVFormatter))
+	l.Logs("buffered")
+
+	if buf.Len() != 0 {
+		t.Errorf("expected output to be buffered but got '%s'", buf.String())
+	}
+
+	if err := l.Sub().Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "msg=buffered\n" {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+}
+
+func TestLogger_Flush_async(t *testing.T) {
+	var buf syncBuffer
+
+	l := kvlog.New(kvlog.NewFilterHandler(kvlog.NewAsyncHandlerWithOptions(&buf, kvlog.KVFormatter, kvlog.HandlerOptions{
+		FlushInterval: time.Hour,
+	}), kvlog.HasKey(kvlog.KeyMessage)))
+	defer l.Close(context.Background())
+
+	l.Logs("pending")
+
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "msg=pending\n" {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+}
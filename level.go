@@ -0,0 +1,50 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import "fmt"
+
+// Level defines the severity of an event. Levels are ordered; a greater value denotes a more severe event.
+type Level int
+
+const (
+	// LevelDebug is used for verbose output only relevant when diagnosing problems.
+	LevelDebug Level = iota
+	// LevelInfo is used for regular operational events.
+	LevelInfo
+	// LevelWarn is used for events that indicate a potential problem.
+	LevelWarn
+	// LevelError is used for events reporting a failure.
+	LevelError
+)
+
+// String returns the lower case name of l as used by the formatters.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
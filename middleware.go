@@ -53,7 +53,7 @@ func Middleware(l Logger, addToContext bool) func(http.Handler) http.Handler {
 			}
 
 			l := l.Sub(
-				WithKV("method", r.Method),
+				WithStr("method", r.Method),
 				WithKV("url", r.URL),
 			)
 
@@ -65,7 +65,7 @@ func Middleware(l Logger, addToContext bool) func(http.Handler) http.Handler {
 
 			requestTime := time.Since(startTime)
 			l.Logs("request",
-				WithKV("status", wrapper.statusCode),
+				WithInt("status", wrapper.statusCode),
 				WithDur(requestTime),
 			)
 		})
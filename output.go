@@ -0,0 +1,103 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// output writes formatted events to a Handler's writer applying the error handling configured by opts.
+type output struct {
+	w    io.Writer
+	opts HandlerOptions
+}
+
+// write writes p to o's writer retrying transient errors. If writing fails, p is written to the fallback
+// writer. All errors are reported to the error callback. The error returned is nil if p has been written to
+// either writer.
+func (o *output) write(p []byte) error {
+	err := o.writeWithRetry(p)
+	if err == nil {
+		return nil
+	}
+
+	o.reportError(err)
+
+	if o.opts.Fallback == nil {
+		return err
+	}
+
+	if _, err = o.opts.Fallback.Write(p); err != nil {
+		o.reportError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (o *output) writeWithRetry(p []byte) error {
+	backoff := o.opts.RetryBackoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for retry := 0; ; retry++ {
+		n, err := o.w.Write(p)
+		if err == nil {
+			return nil
+		}
+
+		if retry >= o.opts.MaxRetries || !isTemporary(err) {
+			return err
+		}
+
+		// Only write the bytes missing after a partial write.
+		p = p[n:]
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// flush flushes o's writer if it buffers data itself (i.e. it provides a Flush method like bufio.Writer).
+func (o *output) flush() error {
+	f, ok := o.w.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+
+	if err := f.Flush(); err != nil {
+		o.reportError(err)
+		return err
+	}
+	return nil
+}
+
+func (o *output) reportError(err error) {
+	if o.opts.OnError != nil {
+		o.opts.OnError(err)
+	}
+}
+
+// isTemporary reports whether err or any error wrapped by err is marked as temporary.
+func isTemporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
@@ -0,0 +1,250 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Kind defines the kind of value stored in a Pair. Scalar values created with one of the typed constructors
+// (such as WithStr or WithInt) are stored without boxing them into an interface{}. Use the accessor matching
+// a Pair's Kind to read its value or Any to get the value regardless of its Kind.
+type Kind uint8
+
+const (
+	// KindAny is the Kind of pairs created with WithKV. The value is stored in the Pair's Value field.
+	KindAny Kind = iota
+	// KindString is the Kind of pairs created with WithStr or WithBytes.
+	KindString
+	// KindInt64 is the Kind of pairs created with WithInt or WithInt64.
+	KindInt64
+	// KindUint64 is the Kind of pairs created with WithUint64.
+	KindUint64
+	// KindFloat64 is the Kind of pairs created with WithFloat.
+	KindFloat64
+	// KindBool is the Kind of pairs created with WithBool.
+	KindBool
+	// KindTime is the Kind of pairs created with WithTime.
+	KindTime
+	// KindDuration is the Kind of pairs created with WithDuration or WithDur.
+	KindDuration
+	// KindGroup is the Kind of pairs created with WithGroup. The Group is stored in the Pair's Value field.
+	KindGroup
+	// KindLazy is the Kind of pairs holding a Valuer that has not been resolved yet. Pairs read from an Event
+	// never have this Kind.
+	KindLazy
+)
+
+// Pair defines a single key-value pair as part of a logging event.
+type Pair struct {
+	Key string
+
+	// Value holds the value of pairs of KindAny and KindGroup. Pairs of any other Kind store their value in
+	// unexported fields and leave Value nil; use Any to read the value of a Pair regardless of its Kind.
+	Value interface{}
+
+	kind Kind
+	num  uint64
+	str  string
+	loc  *time.Location
+
+	// fromLogger marks pairs added by the Logger itself, i.e. the message, level and time.
+	fromLogger bool
+}
+
+// Kind returns the Kind of value stored in p.
+func (p Pair) Kind() Kind {
+	return p.kind
+}
+
+// Str returns the value of a Pair of KindString. The result is undefined for other kinds.
+func (p Pair) Str() string {
+	return p.str
+}
+
+// Int64 returns the value of a Pair of KindInt64. The result is undefined for other kinds.
+func (p Pair) Int64() int64 {
+	return int64(p.num)
+}
+
+// Uint64 returns the value of a Pair of KindUint64. The result is undefined for other kinds.
+func (p Pair) Uint64() uint64 {
+	return p.num
+}
+
+// Float64 returns the value of a Pair of KindFloat64. The result is undefined for other kinds.
+func (p Pair) Float64() float64 {
+	return math.Float64frombits(p.num)
+}
+
+// Bool returns the value of a Pair of KindBool. The result is undefined for other kinds.
+func (p Pair) Bool() bool {
+	return p.num == 1
+}
+
+// Time returns the value of a Pair of KindTime. The result is undefined for other kinds.
+func (p Pair) Time() time.Time {
+	return time.Unix(0, int64(p.num)).In(p.loc)
+}
+
+// Duration returns the value of a Pair of KindDuration. The result is undefined for other kinds.
+func (p Pair) Duration() time.Duration {
+	return time.Duration(p.num)
+}
+
+// Any returns p's value as an interface{} regardless of p's Kind. Calling Any on a Pair of a scalar Kind may
+// allocate. A Pair of KindLazy returns its Valuer.
+func (p Pair) Any() interface{} {
+	switch p.kind {
+	case KindString:
+		return p.Str()
+	case KindInt64:
+		return p.Int64()
+	case KindUint64:
+		return p.Uint64()
+	case KindFloat64:
+		return p.Float64()
+	case KindBool:
+		return p.Bool()
+	case KindTime:
+		return p.Time()
+	case KindDuration:
+		return p.Duration()
+	default:
+		return p.Value
+	}
+}
+
+var (
+	pairPool sync.Pool
+)
+
+const (
+	pairPoolInitialSize = 128
+)
+
+func init() {
+	pairPool = sync.Pool{
+		New: func() interface{} {
+			return &Pair{}
+		},
+	}
+
+	for i := 0; i < pairPoolInitialSize; i++ {
+		pairPool.Put(&Pair{})
+	}
+}
+
+// newPair pulls a Pair from the pool and sets it to v.
+func newPair(v Pair) *Pair {
+	p := pairPool.Get().(*Pair)
+	*p = v
+	return p
+}
+
+// WithKV creates a new Pair to be added to either an Event or a Logger. Pairs are pulled from a pool and are
+// returned once the Event has been created. value is stored as an interface{}; use one of the typed
+// constructors (such as WithStr or WithInt) to avoid allocations for scalar values. If value implements
+// Valuer, it is resolved lazily.
+func WithKV(key string, value interface{}) *Pair {
+	if v, ok := value.(Valuer); ok {
+		return newPair(Pair{Key: key, kind: KindLazy, Value: v})
+	}
+	return newPair(Pair{Key: key, Value: value})
+}
+
+// WithStr creates a Pair with key and the string s.
+func WithStr(key string, s string) *Pair {
+	return newPair(Pair{Key: key, kind: KindString, str: s})
+}
+
+// WithBytes creates a Pair with key and the string value of b. b is copied.
+func WithBytes(key string, b []byte) *Pair {
+	return WithStr(key, string(b))
+}
+
+// WithInt creates a Pair with key and the integer i.
+func WithInt(key string, i int) *Pair {
+	return WithInt64(key, int64(i))
+}
+
+// WithInt64 creates a Pair with key and the integer i.
+func WithInt64(key string, i int64) *Pair {
+	return newPair(Pair{Key: key, kind: KindInt64, num: uint64(i)})
+}
+
+// WithUint64 creates a Pair with key and the unsigned integer u.
+func WithUint64(key string, u uint64) *Pair {
+	return newPair(Pair{Key: key, kind: KindUint64, num: u})
+}
+
+// WithFloat creates a Pair with key and the floating point number f.
+func WithFloat(key string, f float64) *Pair {
+	return newPair(Pair{Key: key, kind: KindFloat64, num: math.Float64bits(f)})
+}
+
+// WithBool creates a Pair with key and the boolean b.
+func WithBool(key string, b bool) *Pair {
+	var n uint64
+	if b {
+		n = 1
+	}
+	return newPair(Pair{Key: key, kind: KindBool, num: n})
+}
+
+// WithTime creates a Pair with key and the time t. The monotonic clock reading of t is dropped. Times outside
+// the range of a nanosecond based Unix timestamp (years 1678 to 2261) are stored as with WithKV.
+func WithTime(key string, t time.Time) *Pair {
+	return newPair(timePair(key, t))
+}
+
+func timePair(key string, t time.Time) Pair {
+	if y := t.Year(); y < 1678 || y > 2261 {
+		return Pair{Key: key, Value: t}
+	}
+	return Pair{Key: key, kind: KindTime, num: uint64(t.UnixNano()), loc: t.Location()}
+}
+
+// WithDuration creates a Pair with key and the duration d.
+func WithDuration(key string, d time.Duration) *Pair {
+	return newPair(Pair{Key: key, kind: KindDuration, num: uint64(d)})
+}
+
+// WithErr creates a Pair with KeyError and err.
+func WithErr(err error) *Pair {
+	return WithKV(KeyError, err)
+}
+
+// WithDur creates a Pair with KeyDuration and d.
+func WithDur(d time.Duration) *Pair {
+	return WithDuration(KeyDuration, d)
+}
+
+// Pairs defines a map of key-value-pairs to be added to an Event.
+type Pairs map[string]interface{}
+
+// WithPairs adds all key-value pairs from p to e and returns e.
+func WithPairs(p Pairs) []*Pair {
+	pairs := make([]*Pair, 0, len(p))
+	for k, v := range p {
+		pairs = append(pairs, WithKV(k, v))
+	}
+	return pairs
+}
@@ -0,0 +1,98 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPair_Any(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+
+	tab := []struct {
+		pair *Pair
+		kind Kind
+		exp  interface{}
+	}{
+		{WithStr("k", "v"), KindString, "v"},
+		{WithBytes("k", []byte("v")), KindString, "v"},
+		{WithInt("k", -17), KindInt64, int64(-17)},
+		{WithInt64("k", 17), KindInt64, int64(17)},
+		{WithUint64("k", 1<<63), KindUint64, uint64(1 << 63)},
+		{WithFloat("k", 1.5), KindFloat64, 1.5},
+		{WithBool("k", true), KindBool, true},
+		{WithBool("k", false), KindBool, false},
+		{WithDuration("k", time.Second), KindDuration, time.Second},
+		{WithKV("k", 17), KindAny, 17},
+	}
+
+	for _, tc := range tab {
+		if tc.pair.Kind() != tc.kind {
+			t.Errorf("%#v: expected kind %d but got %d", tc.exp, tc.kind, tc.pair.Kind())
+		}
+		if tc.pair.Any() != tc.exp {
+			t.Errorf("expected %#v but got %#v", tc.exp, tc.pair.Any())
+		}
+	}
+
+	p := WithTime("k", ts)
+	if p.Kind() != KindTime {
+		t.Errorf("expected kind %d but got %d", KindTime, p.Kind())
+	}
+	if !p.Time().Equal(ts) || p.Time().Location() != time.UTC {
+		t.Errorf("expected %s but got %s", ts, p.Time())
+	}
+
+	zero := WithTime("k", time.Time{})
+	if zero.Kind() != KindAny || !zero.Any().(time.Time).IsZero() {
+		t.Errorf("expected zero time to be stored as KindAny but got %#v", zero)
+	}
+}
+
+func TestTypedPairs_formatters(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	evt := newEvent()
+	evt.AddPair(WithTime("t", ts))
+	evt.AddPair(WithDuration("d", 1500*time.Millisecond))
+	evt.AddPair(WithBool("b", true))
+	evt.AddPair(WithFloat("f", 0.5))
+	evt.AddPair(WithUint64("u", 3))
+	evt.AddPair(WithInt("i", -2))
+	evt.AddPair(WithStr("s", "hello world"))
+
+	var buf bytes.Buffer
+	if err := JSONLFormatter().Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"s":"hello world","i":-2,"u":3,"f":5.00000000e-01,"b":true,"d":"1.500s","t":"2021-03-04T05:06:07Z"}` + "\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+
+	buf.Reset()
+	if err := KVFormatter.Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+	exp = "s=<hello world> i=-2 u=3 f=0.500 b=true d=1.500s t=2021-03-04T05:06:07Z\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
@@ -0,0 +1,209 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errPushCanceled is returned from bufferQueue.push when blocking has been canceled.
+var errPushCanceled = errors.New("kvlog: push canceled")
+
+// queuedBuffer is a formatted event waiting to be written by an asynchronous Handler.
+type queuedBuffer struct {
+	buf *bytes.Buffer
+	// keep marks buffers that must never be dropped due to an overflow.
+	keep bool
+	// flushed marks a request to flush all events queued before. It is closed once these have been written.
+	flushed chan struct{}
+}
+
+// bufferQueue is a bounded FIFO queue of formatted events passed from the goroutines emitting events to the
+// goroutine writing them.
+type bufferQueue struct {
+	mtx     sync.Mutex
+	items   []queuedBuffer
+	size    int
+	closed  bool
+	waiting int
+	// notEmpty is signaled when items have been added or the queue has been closed.
+	notEmpty chan struct{}
+	// notFull is closed (and replaced) to wake up all waiting producers when items have been taken.
+	notFull chan struct{}
+}
+
+func newBufferQueue(size int) *bufferQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	return &bufferQueue{
+		items:    make([]queuedBuffer, 0, size),
+		size:     size,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}),
+	}
+}
+
+// push adds item to q applying policy if q is full. timeout is used with OverflowBlockTimeout. push returns
+// the buffer that has been dropped, if any. If q has been closed, push returns ErrHandlerClosed. If done is
+// closed while blocking, push returns errPushCanceled.
+func (q *bufferQueue) push(item queuedBuffer, policy OverflowPolicy, timeout time.Duration, done <-chan struct{}) (*bytes.Buffer, error) {
+	var deadline <-chan time.Time
+
+	for {
+		q.mtx.Lock()
+
+		if q.closed {
+			q.mtx.Unlock()
+			return nil, ErrHandlerClosed
+		}
+
+		if len(q.items) < q.size {
+			q.items = append(q.items, item)
+			q.signal()
+			q.mtx.Unlock()
+			return nil, nil
+		}
+
+		if !item.keep {
+			switch policy {
+			case OverflowDropNewest:
+				q.mtx.Unlock()
+				return item.buf, nil
+
+			case OverflowBlockTimeout:
+				if timeout <= 0 {
+					q.mtx.Unlock()
+					return item.buf, nil
+				}
+			}
+		}
+
+		if policy == OverflowDropOldest {
+			for i, old := range q.items {
+				if old.keep {
+					continue
+				}
+				copy(q.items[i:], q.items[i+1:])
+				q.items[len(q.items)-1] = item
+				q.mtx.Unlock()
+				return old.buf, nil
+			}
+
+			// All queued buffers must be kept.
+			if !item.keep {
+				q.mtx.Unlock()
+				return item.buf, nil
+			}
+		}
+
+		notFull := q.notFull
+		q.waiting++
+		q.mtx.Unlock()
+
+		if policy == OverflowBlockTimeout && !item.keep && deadline == nil {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			deadline = t.C
+		}
+
+		var dropped *bytes.Buffer
+		var err error
+
+		select {
+		case <-notFull:
+		case <-deadline:
+			dropped = item.buf
+		case <-done:
+			err = errPushCanceled
+		}
+
+		q.mtx.Lock()
+		q.waiting--
+		q.mtx.Unlock()
+
+		if dropped != nil || err != nil {
+			return dropped, err
+		}
+	}
+}
+
+// take appends all queued items to dst and removes them from q. take blocks until at least one item is
+// queued or timeout fires. The boolean result reports whether q has been closed and all items have been
+// taken.
+func (q *bufferQueue) take(dst []queuedBuffer, timeout <-chan time.Time) ([]queuedBuffer, bool) {
+	for {
+		q.mtx.Lock()
+
+		if len(q.items) > 0 {
+			dst = append(dst, q.items...)
+			for i := range q.items {
+				q.items[i] = queuedBuffer{}
+			}
+			q.items = q.items[:0]
+
+			if q.waiting > 0 {
+				close(q.notFull)
+				q.notFull = make(chan struct{})
+			}
+
+			q.mtx.Unlock()
+			return dst, false
+		}
+
+		if q.closed {
+			q.mtx.Unlock()
+			return dst, true
+		}
+
+		q.mtx.Unlock()
+
+		select {
+		case <-q.notEmpty:
+		case <-timeout:
+			return dst, false
+		}
+	}
+}
+
+// close closes q. Producers blocked in push return ErrHandlerClosed; queued items may still be taken.
+func (q *bufferQueue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+	q.signal()
+	close(q.notFull)
+	q.notFull = make(chan struct{})
+}
+
+// signal wakes up a consumer blocked in take. q.mtx must be held by the caller.
+func (q *bufferQueue) signal() {
+	select {
+	case q.notEmpty <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,136 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type samplingKey struct {
+	msg     string
+	level   Level
+	leveled bool
+}
+
+type samplingCounter struct {
+	count      int
+	suppressed int
+}
+
+type samplingHandler struct {
+	handler    Handler
+	first      int
+	thereafter int
+	interval   time.Duration
+
+	mtx       sync.Mutex
+	windowEnd time.Time
+	counters  map[samplingKey]*samplingCounter
+}
+
+// NewSamplingHandler creates a Handler that samples the events delivered to h. Events are counted per
+// message and level. Within each interval, the first events of each message and level are delivered to h;
+// after that, only every thereafter-th event is delivered. If thereafter is less than one, all events
+// after the first are dropped.
+//
+// The number of events dropped since the last delivered event with the same message and level is added to
+// the next delivered event with key KeySuppressed. All counters are reset when the interval ends; events
+// suppressed during an interval are not reported once a new interval has started.
+func NewSamplingHandler(h Handler, first, thereafter int, interval time.Duration) Handler {
+	return &samplingHandler{
+		handler:    h,
+		first:      first,
+		thereafter: thereafter,
+		interval:   interval,
+		counters:   make(map[samplingKey]*samplingCounter),
+	}
+}
+
+func (h *samplingHandler) Flush(ctx context.Context) error {
+	return flushHandler(ctx, h.handler)
+}
+
+func (h *samplingHandler) Close(ctx context.Context) error {
+	return h.handler.Close(ctx)
+}
+
+func (h *samplingHandler) Handle(e *Event) error {
+	suppressed, ok := h.sample(samplingKeyOf(e))
+	if !ok {
+		return nil
+	}
+
+	if suppressed > 0 {
+		e = cloneWith(e, Pair{Key: KeySuppressed, kind: KindInt64, num: uint64(suppressed)})
+	}
+
+	return h.handler.Handle(e)
+}
+
+// sample counts an event with key and reports whether it should be delivered. If so, it returns the number of
+// events suppressed since the last delivered one.
+func (h *samplingHandler) sample(key samplingKey) (suppressed int, ok bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	now := time.Now()
+	if !now.Before(h.windowEnd) {
+		h.windowEnd = now.Add(h.interval)
+		// Drop all counters so the map only holds the messages seen during the current interval.
+		h.counters = make(map[samplingKey]*samplingCounter)
+	}
+
+	c, exists := h.counters[key]
+	if !exists {
+		c = &samplingCounter{}
+		h.counters[key] = c
+	}
+
+	c.count++
+
+	if c.count > h.first && (h.thereafter < 1 || (c.count-h.first)%h.thereafter != 0) {
+		c.suppressed++
+		return 0, false
+	}
+
+	suppressed = c.suppressed
+	c.suppressed = 0
+
+	return suppressed, true
+}
+
+func samplingKeyOf(e *Event) (k samplingKey) {
+	if i := e.index(KeyMessage); i >= 0 {
+		e.resolve(i)
+		k.msg = messageOf(e.pairs[i])
+	}
+	k.level, k.leveled = e.Level()
+	return
+}
+
+// cloneWith returns a clone of e with p added as the least important pair.
+func cloneWith(e *Event, p Pair) *Event {
+	c := e.Clone()
+	c.pairs = append(c.pairs, Pair{})
+	copy(c.pairs[1:], c.pairs)
+	c.pairs[0] = p
+	c.len++
+	return c
+}
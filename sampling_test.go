@@ -0,0 +1,74 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSamplingHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter), 2, 3, time.Hour))
+
+	for i := 0; i < 9; i++ {
+		l.Info("hot", kvlog.WithInt("i", i))
+		l.Warn("hot", kvlog.WithInt("i", i))
+	}
+	l.Logs("other")
+
+	exp := `level=info msg=hot i=0
+level=warn msg=hot i=0
+level=info msg=hot i=1
+level=warn msg=hot i=1
+level=info msg=hot i=4 suppressed=2
+level=warn msg=hot i=4 suppressed=2
+level=info msg=hot i=7 suppressed=2
+level=warn msg=hot i=7 suppressed=2
+msg=other
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestSamplingHandler_interval(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSamplingHandler(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter), 1, 0, 50*time.Millisecond))
+
+	l.Logs("hot")
+	l.Logs("hot")
+	l.Logs("hot")
+
+	time.Sleep(60 * time.Millisecond)
+
+	l.Logs("hot")
+	l.Logs("hot")
+
+	exp := `msg=hot
+msg=hot
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
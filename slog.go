@@ -0,0 +1,247 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build go1.21
+// +build go1.21
+
+package kvlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type slogHandler struct {
+	logger Logger
+}
+
+// NewSlogHandler creates a slog.Handler that emits all records via l. This allows code using log/slog to write
+// through the same Handlers and Formatters as code using kvlog directly.
+//
+// Attributes are converted to Pairs using the typed constructors (such as WithStr or WithInt64); groups are
+// converted to Groups. slog levels are mapped to the kvlog level with the same name; levels in between are
+// mapped to the next lower kvlog level. WithAttrs and WithGroup are mapped to Logger.Sub and Logger.SubGroup.
+//
+// A non-zero record time is added as KeyTime. TimeHook keeps this time, so records built or buffered elsewhere
+// retain their timestamp.
+func NewSlogHandler(l Logger) slog.Handler {
+	return &slogHandler{logger: l}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(levelFromSlog(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	pairs := pairsFromAttrs(attrs)
+	if !r.Time.IsZero() {
+		// The record's time is marked as set by the Logger, so it is not nested into groups and TimeHook keeps it.
+		p := timePair(KeyTime, r.Time)
+		p.fromLogger = true
+		pairs = append(pairs, newPair(p))
+	}
+
+	logAt(h.logger, levelFromSlog(r.Level), r.Message, pairs...)
+
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &slogHandler{logger: h.logger.Sub(pairsFromAttrs(attrs)...)}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{logger: h.logger.SubGroup(name)}
+}
+
+// levelFromSlog maps l to the kvlog Level with the same name or the next lower one.
+func levelFromSlog(l slog.Level) Level {
+	switch {
+	case l < slog.LevelInfo:
+		return LevelDebug
+	case l < slog.LevelWarn:
+		return LevelInfo
+	case l < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// pairsFromAttrs converts attrs to Pairs. As pairs are rendered most important (i.e. last) first, the order of
+// attrs is reversed so that the output follows the order of attrs.
+func pairsFromAttrs(attrs []slog.Attr) []*Pair {
+	pairs := make([]*Pair, 0, len(attrs))
+	for i := len(attrs) - 1; i >= 0; i-- {
+		pairs = appendAttr(pairs, attrs[i])
+	}
+	return pairs
+}
+
+// appendAttr converts a to zero or more Pairs and appends them to pairs. Empty attributes and empty groups are
+// dropped; the attributes of groups without a key are inlined.
+func appendAttr(pairs []*Pair, a slog.Attr) []*Pair {
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		return append(pairs, WithStr(a.Key, v.String()))
+	case slog.KindInt64:
+		return append(pairs, WithInt64(a.Key, v.Int64()))
+	case slog.KindUint64:
+		return append(pairs, WithUint64(a.Key, v.Uint64()))
+	case slog.KindFloat64:
+		return append(pairs, WithFloat(a.Key, v.Float64()))
+	case slog.KindBool:
+		return append(pairs, WithBool(a.Key, v.Bool()))
+	case slog.KindDuration:
+		return append(pairs, WithDuration(a.Key, v.Duration()))
+	case slog.KindTime:
+		return append(pairs, WithTime(a.Key, v.Time()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return pairs
+		}
+		if a.Key == "" {
+			for i := len(attrs) - 1; i >= 0; i-- {
+				pairs = appendAttr(pairs, attrs[i])
+			}
+			return pairs
+		}
+		return append(pairs, WithGroup(a.Key, pairsFromAttrs(attrs)...))
+	default:
+		if a.Key == "" && v.Any() == nil {
+			return pairs
+		}
+		return append(pairs, WithKV(a.Key, v.Any()))
+	}
+}
+
+type slogSinkHandler struct {
+	handler slog.Handler
+}
+
+// NewHandlerFromSlog creates a Handler that emits all events via h. This allows libraries instrumented with
+// kvlog to log through the slog.Handler of an application using log/slog.
+//
+// The record's message is taken from the Pair with key KeyMessage, its time from KeyTime and its level from
+// KeyLevel (defaulting to slog.LevelInfo for events without a level). All other pairs are converted to
+// attributes; groups are converted to attribute groups. Events not enabled by h are dropped before any
+// conversion takes place.
+//
+// Closing the returned Handler is a no-op.
+func NewHandlerFromSlog(h slog.Handler) Handler {
+	return &slogSinkHandler{handler: h}
+}
+
+func (h *slogSinkHandler) Handle(e *Event) error {
+	level := slog.LevelInfo
+	if l, ok := e.Level(); ok {
+		level = levelToSlog(l)
+	}
+
+	ctx := context.Background()
+
+	if !h.handler.Enabled(ctx, level) {
+		return nil
+	}
+
+	var msg string
+	var t time.Time
+	var msgSeen, timeSeen, levelSeen bool
+
+	attrs := make([]slog.Attr, 0, e.Len())
+
+	e.EachPair(func(p Pair) {
+		// Only the most important pair of each key carrying the record's fields is consumed; see Event.Value.
+		switch {
+		case p.Key == KeyMessage && !msgSeen:
+			msgSeen = true
+			msg = messageOf(p)
+			return
+		case p.Key == KeyTime && !timeSeen:
+			timeSeen = true
+			if v, ok := p.Any().(time.Time); ok {
+				t = v
+				return
+			}
+		case p.Key == KeyLevel && !levelSeen:
+			levelSeen = true
+			if _, ok := p.Any().(Level); ok {
+				return
+			}
+		}
+
+		attrs = append(attrs, attrFromPair(p))
+	})
+
+	r := slog.NewRecord(t, level, msg, 0)
+	r.AddAttrs(attrs...)
+
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *slogSinkHandler) Close(context.Context) error { return nil }
+
+// levelToSlog maps l to the slog.Level with the same name. Levels above LevelError are mapped to levels above
+// slog.LevelError using the same distance of 4 between levels.
+func levelToSlog(l Level) slog.Level {
+	return slog.Level(4 * (int(l) - int(LevelInfo)))
+}
+
+// attrFromPair converts p to a slog.Attr.
+func attrFromPair(p Pair) slog.Attr {
+	switch p.Kind() {
+	case KindString:
+		return slog.String(p.Key, p.Str())
+	case KindInt64:
+		return slog.Int64(p.Key, p.Int64())
+	case KindUint64:
+		return slog.Uint64(p.Key, p.Uint64())
+	case KindFloat64:
+		return slog.Float64(p.Key, p.Float64())
+	case KindBool:
+		return slog.Bool(p.Key, p.Bool())
+	case KindTime:
+		return slog.Time(p.Key, p.Time())
+	case KindDuration:
+		return slog.Duration(p.Key, p.Duration())
+	case KindGroup:
+		g := p.Value.(Group)
+		attrs := make([]slog.Attr, 0, len(g))
+		g.EachPair(func(p Pair) {
+			attrs = append(attrs, attrFromPair(p))
+		})
+		return slog.Attr{Key: p.Key, Value: slog.GroupValue(attrs...)}
+	default:
+		return slog.Any(p.Key, p.Any())
+	}
+}
@@ -0,0 +1,249 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build go1.21
+// +build go1.21
+
+package kvlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))))
+
+	l.Info("request",
+		slog.String("method", "GET"),
+		slog.Int("status", 200),
+		slog.Bool("cached", false),
+		slog.Duration("dur", 1500*time.Millisecond),
+		slog.Group("user", slog.Uint64("id", 17), slog.Float64("score", 0.5)),
+		slog.Group("empty"),
+		slog.Group("", slog.String("inlined", "yes")),
+		slog.Any("tags", []string{"a", "b"}),
+	)
+
+	exp := `{"level":"info","msg":"request","method":"GET","status":200,"cached":false,"dur":"1.500s","user":{"id":17,"score":5.00000000e-01},"inlined":"yes","tags":["a","b"]}
+`
+	if withoutTime(buf.String()) != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, withoutTime(buf.String()))
+	}
+}
+
+func TestSlogHandler_levels(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(kvlog.NewSlogHandler(kvlog.NewWithOptions(kvlog.Options{MinLevel: kvlog.LevelInfo},
+		kvlog.NewSyncHandler(&buf, kvlog.KVFormatter))))
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Log(nil, slog.LevelInfo+2, "info+2")
+	l.Warn("warn")
+	l.Error("error")
+	l.Log(nil, slog.LevelError+4, "error+4")
+
+	exp := `level=info msg=info
+level=info msg=info+2
+level=warn msg=warn
+level=error msg=error
+level=error msg=error+4
+`
+	if withoutTime(buf.String()) != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, withoutTime(buf.String()))
+	}
+}
+
+func TestSlogHandler_WithAttrsAndGroup(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))))
+
+	l.With("tracing_id", "1234", "service", "users").
+		WithGroup("db").
+		With("name", "users").
+		Info("query", "rows", 3)
+
+	exp := `{"tracing_id":"1234","service":"users","level":"info","msg":"query","db":{"name":"users","rows":3}}
+`
+	if withoutTime(buf.String()) != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, withoutTime(buf.String()))
+	}
+}
+
+func TestSlogHandler_WithGroup_reservedKeys(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))))
+
+	l.WithGroup("g").Info("hi", "msg", "inner")
+
+	exp := `{"level":"info","msg":"hi","g":{"msg":"inner"}}
+`
+	if withoutTime(buf.String()) != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, withoutTime(buf.String()))
+	}
+}
+
+type slogValuer string
+
+func (v slogValuer) LogValue() slog.Value {
+	return slog.StringValue("resolved " + string(v))
+}
+
+func TestSlogHandler_LogValuer(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))))
+
+	l.Info("msg", slog.Any("v", slogValuer("value")))
+
+	exp := `{"level":"info","msg":"msg","v":"resolved value"}
+`
+	if withoutTime(buf.String()) != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, withoutTime(buf.String()))
+	}
+}
+
+// timePairPattern matches the time added to each record by slog.Logger.
+var timePairPattern = regexp.MustCompile(`,"time":"[^"]*"| time=\S+`)
+
+func withoutTime(s string) string {
+	return timePairPattern.ReplaceAllString(s, "")
+}
+
+func TestSlogHandler_time(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter())).AddHook(kvlog.TimeHook))
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := h.Handle(context.Background(), slog.NewRecord(ts, slog.LevelInfo, "msg", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"level":"info","msg":"msg","time":"2021-03-04T05:06:07Z"}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+
+	buf.Reset()
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(buf.String(), `"time"`) != 1 || strings.Contains(buf.String(), "0001-01-01") {
+		t.Errorf("expected current time but got '%s'", buf.String())
+	}
+}
+
+func TestSlogHandler_slogtest(t *testing.T) {
+	var buf bytes.Buffer
+
+	h := kvlog.NewSlogHandler(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter())))
+
+	results := func() []map[string]interface{} {
+		var ms []map[string]interface{}
+		for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(line, &m); err != nil {
+				t.Fatal(err)
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+
+	if err := slogtest.TestHandler(h, results); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerFromSlog(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewHandlerFromSlog(slog.NewTextHandler(&buf, nil)))
+
+	l.Info("request",
+		kvlog.WithStr("method", "GET"),
+		kvlog.WithInt("status", 200),
+		kvlog.WithGroup("user", kvlog.WithUint64("id", 17), kvlog.WithBool("admin", true)),
+		kvlog.WithKV("tags", []string{"a", "b"}),
+	)
+	l.Logs("no level")
+
+	exp := `level=INFO msg=request tags="[a b]" user.admin=true user.id=17 status=200 method=GET
+level=INFO msg="no level"
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestHandlerFromSlog_time(t *testing.T) {
+	var buf bytes.Buffer
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	l := kvlog.New(kvlog.NewHandlerFromSlog(slog.NewJSONHandler(&buf, nil))).
+		AddHook(kvlog.HookFunc(func(e *kvlog.Event) {
+			e.AddPair(kvlog.WithTime(kvlog.KeyTime, ts))
+		}))
+
+	l.Warn("warning", kvlog.WithDuration("dur", time.Second))
+
+	exp := `{"time":"2021-03-04T05:06:07Z","level":"WARN","msg":"warning","dur":1000000000}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestHandlerFromSlog_levels(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewHandlerFromSlog(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	exp := `level=WARN msg=warn
+level=ERROR msg=error
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
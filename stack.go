@@ -0,0 +1,190 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// MaxStackDepth defines the maximum number of frames captured for a Stack.
+var MaxStackDepth = 64
+
+// Frame describes a single frame of a Stack.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// String returns f formatted as function (file:line).
+func (f Frame) String() string {
+	return f.Function + " (" + f.File + ":" + strconv.Itoa(f.Line) + ")"
+}
+
+// Stack defines a stack trace given as a list of frames, innermost frame first.
+type Stack []Frame
+
+// String returns s with one frame per line.
+func (s Stack) String() string {
+	var b strings.Builder
+	for i, f := range s {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// WithStack creates a Pair with KeyStack and the stack trace of the calling goroutine. The trace starts with the
+// function calling WithStack.
+func WithStack() *Pair {
+	return WithKV(KeyStack, captureStack())
+}
+
+// StackHook is a Hook that adds a Stack as key KeyStack to every event containing a non-nil error as key
+// KeyError. If the error - or any error it wraps - carries a stack trace, this trace is used. Otherwise, the
+// stack trace of the code emitting the event is captured. Events that already contain a KeyStack pair are left
+// unchanged.
+//
+// Errors carry a stack trace if they provide a method named StackTrace or Callers that returns a slice of
+// program counters (i.e. []uintptr). This includes errors created by github.com/pkg/errors.
+var StackHook = HookFunc(func(e *Event) {
+	if e.index(KeyStack) >= 0 {
+		return
+	}
+
+	v, ok := e.Value(KeyError)
+	if !ok {
+		return
+	}
+
+	err, ok := v.(error)
+	if !ok || err == nil || isNilPointer(err) {
+		return
+	}
+
+	s := errorStack(err)
+	if s == nil {
+		s = captureStack()
+	}
+
+	e.add(Pair{Key: KeyStack, Value: s})
+})
+
+// captureStack captures the stack trace of the calling goroutine skipping all frames from package kvlog on top
+// of the stack.
+func captureStack() Stack {
+	pcs := make([]uintptr, MaxStackDepth)
+	n := runtime.Callers(2, pcs)
+	if n == 0 {
+		return Stack{}
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+
+	s := make(Stack, 0, n)
+	for {
+		f, more := frames.Next()
+
+		if len(s) > 0 || !isSkippedFrame(f.Function) {
+			s = append(s, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+
+		if !more {
+			return s
+		}
+	}
+}
+
+// errorStack returns the stack trace carried by err or any error wrapped by err. If multiple errors carry a
+// stack trace, the innermost one is returned as it is the closest to the error's origin. Only the first error
+// wrapped by a multi-error is followed. Walking the chain stops at a nil pointer as calling its methods may
+// panic. nil is returned if no stack trace has been found.
+func errorStack(err error) Stack {
+	var s Stack
+
+	for err != nil && !isNilPointer(err) {
+		if pcs := stackTraceOf(err); pcs != nil {
+			s = framesOf(pcs)
+		}
+
+		cs := causes(err)
+		if len(cs) == 0 {
+			break
+		}
+		err = cs[0]
+	}
+
+	return s
+}
+
+// stackTraceOf returns the program counters returned by err's StackTrace or Callers method. nil is returned if
+// err provides no such method. The method names are passed as constants to MethodByName to keep the linker
+// from retaining all exported methods of all types.
+func stackTraceOf(err error) []uintptr {
+	v := reflect.ValueOf(err)
+
+	if pcs, ok := callPCs(v.MethodByName("StackTrace")); ok {
+		return pcs
+	}
+
+	if pcs, ok := callPCs(v.MethodByName("Callers")); ok {
+		return pcs
+	}
+
+	return nil
+}
+
+// callPCs invokes m if it is a method without arguments returning a slice of uintptr based values (such as
+// github.com/pkg/errors.StackTrace, which is a slice of a named uintptr type) and returns the result.
+func callPCs(m reflect.Value) ([]uintptr, bool) {
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return nil, false
+	}
+
+	t := m.Type().Out(0)
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uintptr {
+		return nil, false
+	}
+
+	r := m.Call(nil)[0]
+	pcs := make([]uintptr, r.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(r.Index(i).Uint())
+	}
+	return pcs, true
+}
+
+func framesOf(pcs []uintptr) Stack {
+	if len(pcs) == 0 {
+		return Stack{}
+	}
+
+	frames := runtime.CallersFrames(pcs)
+	s := make(Stack, 0, len(pcs))
+	for {
+		f, more := frames.Next()
+		s = append(s, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		if !more {
+			return s
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+// frame and stackTrace mimic the types used by github.com/pkg/errors.
+type frame uintptr
+type stackTrace []frame
+
+type stackError struct {
+	msg string
+	pcs []uintptr
+}
+
+func newStackError(msg string) error {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	return &stackError{msg: msg, pcs: pcs[:n]}
+}
+
+func (e *stackError) Error() string { return e.msg }
+
+func (e *stackError) StackTrace() stackTrace {
+	st := make(stackTrace, len(e.pcs))
+	for i, pc := range e.pcs {
+		st[i] = frame(pc)
+	}
+	return st
+}
+
+func originOfError() error {
+	return newStackError("origin")
+}
+
+func stackOf(pairs ...*kvlog.Pair) kvlog.Stack {
+	var s kvlog.Stack
+
+	l := kvlog.New(kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		if v, ok := e.Value(kvlog.KeyStack); ok {
+			s = v.(kvlog.Stack)
+		}
+		return nil
+	})).AddHook(kvlog.StackHook)
+
+	l.Logs("test", pairs...)
+
+	return s
+}
+
+func TestStackHook_captured(t *testing.T) {
+	s := stackOf(kvlog.WithErr(errors.New("failed")))
+
+	if len(s) == 0 || s[0].Function != "github.com/halimath/kvlog_test.stackOf" {
+		t.Errorf("unexpected stack: %s", s)
+	}
+}
+
+func TestStackHook_fromError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", originOfError())
+	s := stackOf(kvlog.WithErr(err))
+
+	if len(s) == 0 || s[0].Function != "github.com/halimath/kvlog_test.originOfError" {
+		t.Errorf("unexpected stack: %s", s)
+	}
+}
+
+func TestStackHook_noError(t *testing.T) {
+	if s := stackOf(kvlog.WithKV("foo", "bar")); s != nil {
+		t.Errorf("unexpected stack: %s", s)
+	}
+}
+
+// wrappingError dereferences its receiver in Unwrap and thus panics when called on a nil pointer.
+type wrappingError struct {
+	cause error
+}
+
+func (e *wrappingError) Error() string { return "wrapping" }
+
+func (e *wrappingError) Unwrap() error { return e.cause }
+
+func TestStackHook_nilPointerError(t *testing.T) {
+	if s := stackOf(kvlog.WithErr((*wrappingError)(nil))); s != nil {
+		t.Errorf("unexpected stack: %s", s)
+	}
+}
+
+func TestStackHook_nilPointerCause(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &wrappingError{cause: (*wrappingError)(nil)})
+	s := stackOf(kvlog.WithErr(err))
+
+	if len(s) == 0 || s[0].Function != "github.com/halimath/kvlog_test.stackOf" {
+		t.Errorf("unexpected stack: %s", s)
+	}
+}
+
+func TestStack_JSONLFormatter(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+	l.Logs("stack", kvlog.WithStack())
+
+	var got struct {
+		Stack []struct {
+			Func string `json:"func"`
+			File string `json:"file"`
+			Line int    `json:"line"`
+		} `json:"stack"`
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Stack) == 0 || got.Stack[0].Func != "github.com/halimath/kvlog_test.TestStack_JSONLFormatter" || got.Stack[0].Line == 0 {
+		t.Errorf("unexpected stack: %s", buf.String())
+	}
+}
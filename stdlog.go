@@ -0,0 +1,177 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NewWriter creates an io.Writer that emits each line written to it as an event with the given level via l.
+// The line (without the trailing line break) becomes the event's message. Incomplete lines are buffered until
+// the line break is written. The returned writer is safe for concurrent use.
+func NewWriter(l Logger, level Level) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+// NewStdLogWriter creates an io.Writer like NewWriter that parses each line's header written by a log.Logger
+// using prefix and flags. The prefix as well as date and time are removed from the message; a file name and
+// line number are added as a Caller with key KeyCaller. A CallerHook added to l keeps this Caller.
+func NewStdLogWriter(l Logger, level Level, prefix string, flags int) io.Writer {
+	return &lineWriter{logger: l, level: level, parseHeader: true, prefix: prefix, flags: flags}
+}
+
+// NewStdLogger creates a log.Logger that emits each line as an event with the given level via l. This is
+// useful to integrate code that logs via a log.Logger, such as http.Server.ErrorLog. Add a CallerHook to l to
+// record the source code location; frames from package log are skipped.
+func NewStdLogger(l Logger, level Level) *log.Logger {
+	return log.New(NewWriter(l, level), "", 0)
+}
+
+// RedirectStdLog redirects the output of the standard logger from package log to l (usually L), emitting each
+// line as an event with LevelInfo. The standard logger's prefix and flags are cleared as l adds its own pairs.
+// The returned function restores the standard logger's previous output, prefix and flags.
+func RedirectStdLog(l Logger) (restore func()) {
+	out, prefix, flags := log.Writer(), log.Prefix(), log.Flags()
+
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(NewWriter(l, LevelInfo))
+
+	return func() {
+		log.SetOutput(out)
+		log.SetPrefix(prefix)
+		log.SetFlags(flags)
+	}
+}
+
+type lineWriter struct {
+	mtx         sync.Mutex
+	logger      Logger
+	level       Level
+	parseHeader bool
+	prefix      string
+	flags       int
+	buf         []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	start := 0
+	for {
+		i := bytes.IndexByte(w.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimSuffix(string(w.buf[start:start+i]), "\r"))
+		start += i + 1
+	}
+
+	n := copy(w.buf, w.buf[start:])
+	w.buf = w.buf[:n]
+
+	return len(p), nil
+}
+
+func (w *lineWriter) emit(line string) {
+	if !w.logger.Enabled(w.level) {
+		return
+	}
+
+	if !w.parseHeader {
+		logAt(w.logger, w.level, line)
+		return
+	}
+
+	msg, caller, ok := w.parse(line)
+	if !ok {
+		logAt(w.logger, w.level, msg)
+		return
+	}
+	logAt(w.logger, w.level, msg, WithKV(KeyCaller, caller))
+}
+
+// parse removes the header written by a log.Logger using w's prefix and flags from line. It returns the
+// remaining message as well as the Caller if the header contains a file name and line number.
+func (w *lineWriter) parse(line string) (msg string, caller Caller, ok bool) {
+	if w.flags&log.Lmsgprefix == 0 {
+		line = strings.TrimPrefix(line, w.prefix)
+	}
+
+	if w.flags&log.Ldate != 0 {
+		line = skipHeaderField(line, len("2006/01/02 "))
+	}
+
+	if w.flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		n := len("15:04:05 ")
+		if w.flags&log.Lmicroseconds != 0 {
+			n += len(".000000")
+		}
+		line = skipHeaderField(line, n)
+	}
+
+	if w.flags&(log.Lshortfile|log.Llongfile) != 0 {
+		if i := strings.Index(line, ": "); i >= 0 {
+			loc := line[:i]
+			if j := strings.LastIndexByte(loc, ':'); j >= 0 {
+				if n, err := strconv.Atoi(loc[j+1:]); err == nil {
+					caller = Caller{File: loc[:j], Line: n}
+					ok = true
+					line = line[i+2:]
+				}
+			}
+		}
+	}
+
+	if w.flags&log.Lmsgprefix != 0 {
+		line = strings.TrimPrefix(line, w.prefix)
+	}
+
+	msg = line
+	return
+}
+
+// skipHeaderField removes the first n bytes from line if line is long enough to contain the field.
+func skipHeaderField(line string, n int) string {
+	if len(line) < n {
+		return line
+	}
+	return line[n:]
+}
+
+// logAt emits msg and pairs via l using the method for level.
+func logAt(l Logger, level Level, msg string, pairs ...*Pair) {
+	switch {
+	case level <= LevelDebug:
+		l.Debug(msg, pairs...)
+	case level == LevelInfo:
+		l.Info(msg, pairs...)
+	case level == LevelWarn:
+		l.Warn(msg, pairs...)
+	default:
+		l.Error(msg, pairs...)
+	}
+}
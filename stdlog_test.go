@@ -0,0 +1,185 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestNewWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := kvlog.NewWriter(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter())), kvlog.LevelWarn)
+
+	fmt.Fprint(w, "first line\nsecond")
+	fmt.Fprint(w, " line\r\nthird ")
+
+	exp := `{"level":"warn","msg":"first line"}
+{"level":"warn","msg":"second line"}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+
+	fmt.Fprint(w, "line\n")
+
+	exp += `{"level":"warn","msg":"third line"}
+`
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestNewWriter_disabledLevel(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.NewWithOptions(kvlog.Options{MinLevel: kvlog.LevelInfo}, kvlog.NewSyncHandler(&buf, kvlog.KVFormatter))
+	w := kvlog.NewWriter(l, kvlog.LevelDebug)
+
+	fmt.Fprintln(w, "debug")
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no output but got '%s'", buf.String())
+	}
+}
+
+func TestNewStdLogWriter(t *testing.T) {
+	tests := []struct {
+		prefix string
+		flags  int
+	}{
+		{"", 0},
+		{"app: ", log.LstdFlags},
+		{"app: ", log.LstdFlags | log.Lmicroseconds | log.LUTC},
+		{"app: ", log.LstdFlags | log.Lmsgprefix},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter))
+		log.New(kvlog.NewStdLogWriter(l, kvlog.LevelError, test.prefix, test.flags), test.prefix, test.flags).
+			Print("failed to accept connection")
+
+		exp := "level=error msg=<failed to accept connection>\n"
+		if buf.String() != exp {
+			t.Errorf("%q/%d: expected '%s' but got '%s'", test.prefix, test.flags, exp, buf.String())
+		}
+	}
+}
+
+func TestNewStdLogWriter_file(t *testing.T) {
+	var caller kvlog.Caller
+	var msg interface{}
+
+	h := kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		c, _ := e.Value(kvlog.KeyCaller)
+		caller = c.(kvlog.Caller)
+		msg, _ = e.Value(kvlog.KeyMessage)
+		return nil
+	})
+
+	flags := log.LstdFlags | log.Lshortfile | log.Lmsgprefix
+	sl := log.New(kvlog.NewStdLogWriter(kvlog.New(h), kvlog.LevelInfo, "app: ", flags), "app: ", flags)
+
+	_, _, line, _ := runtime.Caller(0)
+	sl.Print("started: listening")
+
+	if caller.File != "stdlog_test.go" || caller.Line != line+1 {
+		t.Errorf("expected stdlog_test.go:%d but got %s", line+1, caller)
+	}
+
+	if msg != "started: listening" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+}
+
+func TestNewStdLogWriter_fileWithCallerHook(t *testing.T) {
+	var callers []kvlog.Caller
+
+	h := kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		e.EachPair(func(p kvlog.Pair) {
+			if p.Key == kvlog.KeyCaller {
+				callers = append(callers, p.Value.(kvlog.Caller))
+			}
+		})
+		return nil
+	})
+
+	flags := log.Lshortfile
+	sl := log.New(kvlog.NewStdLogWriter(kvlog.New(h).AddHook(kvlog.CallerHook), kvlog.LevelInfo, "", flags), "", flags)
+
+	_, _, line, _ := runtime.Caller(0)
+	sl.Print("started")
+
+	if len(callers) != 1 {
+		t.Fatalf("expected one caller but got %v", callers)
+	}
+
+	if callers[0].File != "stdlog_test.go" || callers[0].Line != line+1 {
+		t.Errorf("expected stdlog_test.go:%d but got %s", line+1, callers[0])
+	}
+}
+
+func TestNewStdLogger_caller(t *testing.T) {
+	var callers []kvlog.Caller
+
+	h := kvlog.HandlerFunc(func(e *kvlog.Event) error {
+		c, _ := e.Value(kvlog.KeyCaller)
+		callers = append(callers, c.(kvlog.Caller))
+		return nil
+	})
+
+	sl := kvlog.NewStdLogger(kvlog.New(h).AddHook(kvlog.CallerHook), kvlog.LevelInfo)
+
+	_, _, line, _ := runtime.Caller(0)
+	sl.Printf("%s", "printf")
+
+	if len(callers) != 1 {
+		t.Fatalf("expected one event but got %d", len(callers))
+	}
+
+	if filepath.Base(callers[0].File) != "stdlog_test.go" || callers[0].Line != line+1 {
+		t.Errorf("expected stdlog_test.go:%d but got %s", line+1, callers[0])
+	}
+}
+
+func TestRedirectStdLog(t *testing.T) {
+	var buf bytes.Buffer
+
+	out := log.Writer()
+	restore := kvlog.RedirectStdLog(kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.KVFormatter)))
+
+	log.Print("redirected")
+	restore()
+
+	if buf.String() != "level=info msg=redirected\n" {
+		t.Errorf("unexpected output: '%s'", buf.String())
+	}
+
+	if log.Writer() != out || log.Flags() != log.LstdFlags {
+		t.Errorf("standard logger not restored")
+	}
+}
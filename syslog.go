@@ -0,0 +1,466 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyslogTimeout defines the timeout used by a SyslogWriter when connecting and writing.
+var SyslogTimeout = 5 * time.Second
+
+// SyslogFormat selects the format of syslog messages.
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 selects the format defined by RFC 5424. Pairs are rendered as STRUCTURED-DATA.
+	SyslogRFC5424 SyslogFormat = iota
+	// SyslogRFC3164 selects the legacy BSD format defined by RFC 3164. Pairs are appended to the message
+	// using the KV format.
+	SyslogRFC3164
+)
+
+// SyslogFacility defines the facility of syslog messages.
+type SyslogFacility int
+
+// Facilities as defined by RFC 5424. The rarely used facilities 12 to 15 are omitted.
+const (
+	SyslogKern SyslogFacility = iota
+	SyslogUser
+	SyslogMail
+	SyslogDaemon
+	SyslogAuth
+	SyslogSyslog
+	SyslogLpr
+	SyslogNews
+	SyslogUucp
+	SyslogCron
+	SyslogAuthpriv
+	SyslogFtp
+	_
+	_
+	_
+	_
+	SyslogLocal0
+	SyslogLocal1
+	SyslogLocal2
+	SyslogLocal3
+	SyslogLocal4
+	SyslogLocal5
+	SyslogLocal6
+	SyslogLocal7
+)
+
+// DefaultSyslogSDID defines the default SD-ID of the STRUCTURED-DATA element holding an event's pairs. It
+// uses the private enterprise number reserved for documentation; set SyslogOptions.SDID to use your own.
+const DefaultSyslogSDID = "kvlog@32473"
+
+// SyslogOptions defines the options used to configure a syslog Formatter.
+type SyslogOptions struct {
+	// Format selects the format of the messages. Defaults to SyslogRFC5424.
+	Format SyslogFormat
+
+	// Facility defines the facility of the messages. As SyslogKern is reserved for kernel messages, the zero
+	// value selects SyslogUser.
+	Facility SyslogFacility
+
+	// Hostname defines the hostname of the messages. Defaults to the name reported by os.Hostname.
+	Hostname string
+
+	// AppName defines the name of the application. Defaults to the base name of the executable.
+	AppName string
+
+	// ProcID defines the process id. Defaults to the process' pid.
+	ProcID string
+
+	// MsgID defines the type of the messages. Only used by SyslogRFC5424. Defaults to the nil value.
+	MsgID string
+
+	// SDID defines the SD-ID of the STRUCTURED-DATA element holding an event's pairs. Only used by
+	// SyslogRFC5424. Defaults to DefaultSyslogSDID.
+	SDID string
+}
+
+type syslogFormatter struct {
+	opts     SyslogOptions
+	hostname string
+	appName  string
+	procID   string
+	msgID    string
+	sdid     string
+	buf      bytes.Buffer
+}
+
+// SyslogFormatter creates a Formatter that formats syslog messages. The message is taken from KeyMessage, the
+// severity from KeyLevel and the timestamp from KeyTime (using the current time if missing). All other pairs
+// are rendered as STRUCTURED-DATA (RFC 5424) or in the KV format (RFC 3164). Each message is terminated by a
+// line break; line breaks contained in the message are escaped as \n.
+func SyslogFormatter(opts SyslogOptions) Formatter {
+	if opts.Facility == SyslogKern {
+		opts.Facility = SyslogUser
+	}
+
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+
+	if opts.ProcID == "" {
+		opts.ProcID = strconv.Itoa(os.Getpid())
+	}
+
+	if opts.SDID == "" {
+		opts.SDID = DefaultSyslogSDID
+	}
+
+	return &syslogFormatter{
+		opts:     opts,
+		hostname: syslogHeaderField(opts.Hostname, 255),
+		appName:  syslogHeaderField(opts.AppName, 48),
+		procID:   syslogHeaderField(opts.ProcID, 128),
+		msgID:    syslogHeaderField(opts.MsgID, 32),
+		sdid:     syslogName(opts.SDID),
+	}
+}
+
+func (f *syslogFormatter) Format(w io.Writer, e *Event) error {
+	f.buf.Reset()
+
+	l, ok := e.Level()
+	pri := int(f.opts.Facility)*8 + syslogSeverity(l, ok)
+
+	t := time.Now()
+	if v, ok := e.Value(KeyTime); ok {
+		if ts, ok := v.(time.Time); ok {
+			t = ts
+		}
+	}
+
+	var msg string
+	if i := e.index(KeyMessage); i >= 0 {
+		msg = messageOf(resolved(e.pairs[i]))
+	}
+
+	if f.opts.Format == SyslogRFC3164 {
+		f.format3164(e, pri, t, msg)
+	} else {
+		f.format5424(e, pri, t, msg)
+	}
+
+	out := escapeLineBreaks(f.buf.Bytes())
+	_, err := w.Write(append(out, '\n'))
+	return err
+}
+
+func (f *syslogFormatter) format5424(e *Event, pri int, t time.Time, msg string) {
+	fmt.Fprintf(&f.buf, "<%d>1 %s %s %s %s %s ", pri, t.Format("2006-01-02T15:04:05.000000Z07:00"), f.hostname,
+		f.appName, f.procID, f.msgID)
+
+	var n int
+	eachSyslogPair(e, func(p Pair) {
+		if n == 0 {
+			f.buf.WriteByte('[')
+			f.buf.WriteString(f.sdid)
+		}
+		n++
+
+		f.buf.WriteByte(' ')
+		f.buf.WriteString(syslogName(p.Key))
+		f.buf.WriteString(`="`)
+		writeSyslogParamValue(&f.buf, syslogValue(p))
+		f.buf.WriteByte('"')
+	})
+
+	if n == 0 {
+		f.buf.WriteByte('-')
+	} else {
+		f.buf.WriteByte(']')
+	}
+
+	if msg != "" {
+		f.buf.WriteByte(' ')
+		f.buf.WriteString(msg)
+	}
+}
+
+func (f *syslogFormatter) format3164(e *Event, pri int, t time.Time, msg string) {
+	fmt.Fprintf(&f.buf, "<%d>%s %s %s[%s]: %s", pri, t.Format(time.Stamp), f.hostname, f.appName, f.procID, msg)
+
+	eachSyslogPair(e, func(p Pair) {
+		f.buf.WriteByte(' ')
+		formatPair(&f.buf, p)
+	})
+}
+
+// eachSyslogPair applies f to all pairs of e not rendered as part of a syslog message's header. Groups are
+// flattened.
+func eachSyslogPair(e *Event, f func(Pair)) {
+	e.EachPair(func(p Pair) {
+		if p.Key == KeyTime || p.Key == KeyLevel || p.Key == KeyMessage {
+			return
+		}
+		eachFlatPair(p, f)
+	})
+}
+
+// syslogSeverity maps l to a syslog severity. Events without a level are reported as informational.
+func syslogSeverity(l Level, ok bool) int {
+	switch {
+	case !ok:
+		return 6
+	case l >= LevelError:
+		return 3
+	case l >= LevelWarn:
+		return 4
+	case l >= LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// syslogValue returns the textual representation of p's value used for SD-PARAMs.
+func syslogValue(p Pair) string {
+	if p.Kind() == KindTime {
+		return p.Time().Format(time.RFC3339)
+	}
+
+	switch x := p.Any().(type) {
+	case nil:
+		return "null"
+	case time.Time:
+		return x.Format(time.RFC3339)
+	}
+
+	return messageOf(p)
+}
+
+// syslogHeaderField converts s to a header field consisting of at most max printable US-ASCII characters.
+// Other characters are replaced with an underscore. An empty s is converted to the nil value.
+func syslogHeaderField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+
+	b := []byte(s)
+	if len(b) > max {
+		b = b[:max]
+	}
+
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+// syslogName converts s to a valid SD-NAME replacing invalid characters with an underscore.
+func syslogName(s string) string {
+	b := []byte(syslogHeaderField(s, 32))
+	for i, c := range b {
+		if c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// writeSyslogParamValue writes s to buf escaping '"', '\' and ']'.
+func writeSyslogParamValue(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+}
+
+// escapeLineBreaks replaces all line breaks in b with \n and \r respectively.
+func escapeLineBreaks(b []byte) []byte {
+	if bytes.IndexAny(b, "\r\n") < 0 {
+		return b
+	}
+
+	b = bytes.ReplaceAll(b, []byte("\n"), []byte(`\n`))
+	return bytes.ReplaceAll(b, []byte("\r"), []byte(`\r`))
+}
+
+// SyslogWriter is an io.Writer sending syslog messages to a syslog server via a unix domain socket, UDP or
+// TCP. Each line written is sent as a single message. Messages sent via stream sockets (tcp, unix) are framed
+// using octet counting (RFC 6587); messages sent via datagram sockets (udp, unixgram) are sent as a single
+// datagram each.
+//
+// If sending fails, the SyslogWriter reconnects and sends the messages not written so far once more before
+// reporting an error. Messages are never sent twice; messages written to the socket right before the
+// connection failed may be lost, though, as a failure is only detected when writing. A SyslogWriter is safe
+// for concurrent use.
+type SyslogWriter struct {
+	mtx     sync.Mutex
+	network string
+	addr    string
+	stream  bool
+	conn    net.Conn
+	closed  bool
+	buf     bytes.Buffer
+	// ends holds the offsets in buf at which the frame of each message ends.
+	ends []int
+}
+
+// DialSyslog creates a SyslogWriter connected to the syslog server listening on addr. network must be one of
+// unixgram, unix, udp, udp4, udp6, tcp, tcp4 or tcp6. Use SyslogFormatter to format the messages.
+func DialSyslog(network, addr string) (*SyslogWriter, error) {
+	w := &SyslogWriter{
+		network: network,
+		addr:    addr,
+	}
+
+	switch network {
+	case "unix", "tcp", "tcp4", "tcp6":
+		w.stream = true
+	case "unixgram", "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("kvlog: unsupported syslog network: %s", network)
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write sends each line contained in p as a syslog message.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+
+	msgs := bytes.Split(p, []byte("\n"))
+
+	sent, err := w.send(msgs)
+	if err != nil {
+		w.disconnect()
+		if _, err = w.send(msgs[sent:]); err != nil {
+			w.disconnect()
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes the connection to the syslog server.
+func (w *SyslogWriter) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// send sends msgs to the server, connecting if necessary. Empty messages are skipped. It returns the number
+// of messages written completely, so a retry resends only the remaining ones. w.mtx must be held by the
+// caller.
+func (w *SyslogWriter) send(msgs [][]byte) (int, error) {
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(SyslogTimeout)); err != nil {
+		return 0, err
+	}
+
+	if w.stream {
+		w.buf.Reset()
+		w.ends = w.ends[:0]
+		for _, m := range msgs {
+			if len(m) > 0 {
+				w.buf.WriteString(strconv.Itoa(len(m)))
+				w.buf.WriteByte(' ')
+				w.buf.Write(m)
+			}
+			w.ends = append(w.ends, w.buf.Len())
+		}
+
+		if n, err := w.conn.Write(w.buf.Bytes()); err != nil {
+			// A partially written frame is discarded by the server once the connection is closed, so it
+			// is sent again.
+			return sort.SearchInts(w.ends, n+1), err
+		}
+		return len(msgs), nil
+	}
+
+	for i, m := range msgs {
+		if len(m) == 0 {
+			continue
+		}
+		if _, err := w.conn.Write(m); err != nil {
+			return i, err
+		}
+	}
+
+	return len(msgs), nil
+}
+
+// connect connects to the server. w.mtx must be held by the caller.
+func (w *SyslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.addr, SyslogTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// disconnect closes the connection to the server. w.mtx must be held by the caller.
+func (w *SyslogWriter) disconnect() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
@@ -0,0 +1,342 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogTestTime = time.Date(2021, 3, 4, 5, 6, 7, 8000, time.UTC)
+
+func syslogTestEvent(level Level, msg string, pairs ...*Pair) *Event {
+	evt := newEvent()
+	for _, p := range pairs {
+		evt.AddPair(p)
+	}
+	evt.AddPair(WithKV(KeyMessage, msg))
+	evt.AddPair(WithKV(KeyLevel, level))
+	evt.AddPair(WithTime(KeyTime, syslogTestTime))
+	return evt
+}
+
+func syslogTestOptions() SyslogOptions {
+	return SyslogOptions{
+		Facility: SyslogLocal0,
+		Hostname: "host.example.com",
+		AppName:  "app",
+		ProcID:   "42",
+	}
+}
+
+func TestSyslogFormatter_rfc5424(t *testing.T) {
+	tab := map[*Event]string{
+		syslogTestEvent(LevelInfo, "started", WithStr("port", "8080"), WithInt("workers", 4)): `<134>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - [kvlog@32473 workers="4" port="8080"] started`,
+		syslogTestEvent(LevelError, "failed"):                                                 `<131>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - - failed`,
+		syslogTestEvent(LevelWarn, ""):                                                        `<132>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - -`,
+		syslogTestEvent(LevelDebug, "escaped", WithStr("v", `a "quoted" \ ]`)):                `<135>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - [kvlog@32473 v="a \"quoted\" \\ \]"] escaped`,
+		syslogTestEvent(LevelInfo, "line\nbreak", WithStr("na=me ]", "x")):                    `<134>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - [kvlog@32473 na_me__="x"] line\nbreak`,
+		syslogTestEvent(LevelInfo, "grouped", WithGroup("req", WithStr("method", "GET"))):     `<134>1 2021-03-04T05:06:07.000008Z host.example.com app 42 - [kvlog@32473 req.method="GET"] grouped`,
+	}
+
+	for evt, exp := range tab {
+		var buf bytes.Buffer
+		if err := SyslogFormatter(syslogTestOptions()).Format(&buf, evt); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != exp+"\n" {
+			t.Errorf("expected '%s' but got '%s'", exp+"\n", buf.String())
+		}
+	}
+}
+
+func TestSyslogFormatter_rfc5424Options(t *testing.T) {
+	evt := newEvent()
+	evt.AddPair(WithKV(KeyMessage, "message"))
+
+	var buf bytes.Buffer
+	f := SyslogFormatter(SyslogOptions{
+		Hostname: "my host",
+		AppName:  strings.Repeat("a", 50),
+		ProcID:   "1",
+		MsgID:    "REQ",
+		SDID:     "app@1234",
+	})
+	if err := f.Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := " my_host " + strings.Repeat("a", 48) + " 1 REQ - message\n"
+	if !strings.HasPrefix(buf.String(), "<14>1 ") || !strings.HasSuffix(buf.String(), exp) {
+		t.Errorf("expected '<14>1 ...%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestSyslogFormatter_rfc3164(t *testing.T) {
+	opts := syslogTestOptions()
+	opts.Format = SyslogRFC3164
+
+	var buf bytes.Buffer
+	evt := syslogTestEvent(LevelWarn, "slow request", WithStr("path", "/index.html"), WithDur(1500*time.Millisecond))
+	if err := SyslogFormatter(opts).Format(&buf, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "<132>Mar  4 05:06:07 host.example.com app[42]: slow request dur=1.500s path=/index.html\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}
+
+func TestSyslogWriter_udp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testSyslogDatagrams(t, conn, "udp")
+}
+
+func TestSyslogWriter_unixgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram is not supported on windows")
+	}
+
+	conn, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testSyslogDatagrams(t, conn, "unixgram")
+}
+
+func testSyslogDatagrams(t *testing.T, conn net.PacketConn, network string) {
+	t.Helper()
+
+	w, err := DialSyslog(network, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("<14>1 - - - - - - first\n<14>1 - - - - - - second\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, exp := range []string{"<14>1 - - - - - - first", "<14>1 - - - - - - second"} {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != exp {
+			t.Errorf("expected '%s' but got '%s'", exp, string(buf[:n]))
+		}
+	}
+}
+
+func TestSyslogWriter_tcp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					msg, err := readOctetCounted(r)
+					if err != nil {
+						return
+					}
+					received <- msg
+				}
+			}()
+		}
+	}()
+
+	w, err := DialSyslog("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	receive := func(exp string) {
+		t.Helper()
+
+		select {
+		case msg := <-received:
+			// Strip the timestamp which is set to the current time.
+			if i := strings.Index(msg, " host."); i > 0 {
+				msg = "<134>1 -" + msg[i:]
+			}
+			if msg != exp {
+				t.Errorf("expected '%s' but got '%s'", exp, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected '%s'", exp)
+		}
+	}
+
+	l := New(NewSyncHandler(w, SyslogFormatter(syslogTestOptions())))
+
+	l.Logs("first", WithStr("n", "1"))
+	receive(`<134>1 - host.example.com app 42 - [kvlog@32473 n="1"] first`)
+
+	// Drop the connection; the writer must reconnect.
+	w.mtx.Lock()
+	w.conn.Close()
+	w.mtx.Unlock()
+
+	l.Logs("second\nline")
+	receive(`<134>1 - host.example.com app 42 - - second\nline`)
+}
+
+// partialConn accepts the first limit bytes written and fails afterwards.
+type partialConn struct {
+	net.Conn
+	limit   int
+	written bytes.Buffer
+}
+
+func (c *partialConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		c.written.Write(p[:c.limit])
+		return c.limit, io.ErrClosedPipe
+	}
+	c.limit -= len(p)
+	return c.written.Write(p)
+}
+
+func (c *partialConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *partialConn) Close() error { return nil }
+
+func TestSyslogWriter_tcpPartialWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w, err := DialSyslog("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Drop the initial connection and accept the one created when reconnecting.
+	first, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := readOctetCounted(r)
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	// The first frame ("5 first") is written completely, the second one partially.
+	conn := &partialConn{limit: 10}
+	w.mtx.Lock()
+	w.conn = conn
+	w.mtx.Unlock()
+
+	if _, err := w.Write([]byte("first\nsecond\nthird\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.written.String() != "5 first6 s" {
+		t.Errorf("expected '5 first6 s' but got '%s'", conn.written.String())
+	}
+
+	for _, exp := range []string{"second", "third"} {
+		select {
+		case msg := <-received:
+			if msg != exp {
+				t.Errorf("expected '%s' but got '%s'", exp, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected '%s'", exp)
+		}
+	}
+
+	select {
+	case msg := <-received:
+		t.Errorf("unexpected message '%s'", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDialSyslog_unsupportedNetwork(t *testing.T) {
+	if _, err := DialSyslog("ip", "127.0.0.1"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func readOctetCounted(r *bufio.Reader) (string, error) {
+	l, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(l, " "))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+
+	return string(msg), nil
+}
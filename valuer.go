@@ -0,0 +1,67 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog
+
+// Valuer defines the interface for values that are computed lazily. A Pair holding a Valuer is resolved the
+// first time the Pair is read from an Event, i.e. when a Formatter runs. Events that are discarded before being
+// formatted never resolve their Valuers. Each Valuer is resolved at most once per Event even if the Event is
+// delivered to several Handlers.
+type Valuer interface {
+	// LogValue returns the value to log.
+	LogValue() interface{}
+}
+
+// ValuerFunc is a convenience type used to implement a Valuer as a simple function.
+type ValuerFunc func() interface{}
+
+// LogValue simply calls vf.
+func (vf ValuerFunc) LogValue() interface{} { return vf() }
+
+// WithLazy creates a Pair with key whose value is computed by calling f when the Pair is read from an Event.
+func WithLazy(key string, f func() interface{}) *Pair {
+	return newPair(Pair{Key: key, kind: KindLazy, Value: ValuerFunc(f)})
+}
+
+// resolved returns p with all Valuers resolved. For groups, a copy of the group is created if any of the
+// pairs - including the pairs of nested groups - needs to be resolved. All other pairs are returned unchanged.
+func resolved(p Pair) Pair {
+	switch p.kind {
+	case KindLazy:
+		p.kind = KindAny
+		p.Value = p.Value.(Valuer).LogValue()
+	case KindGroup:
+		g := p.Value.(Group)
+		if needsResolving(g) {
+			c := make(Group, len(g))
+			for i := range g {
+				c[i] = resolved(g[i])
+			}
+			p.Value = c
+		}
+	}
+	return p
+}
+
+func needsResolving(g Group) bool {
+	for _, p := range g {
+		if p.kind == KindLazy || (p.kind == KindGroup && needsResolving(p.Value.(Group))) {
+			return true
+		}
+	}
+	return false
+}
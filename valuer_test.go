@@ -0,0 +1,97 @@
+// This file is part of kvlog.
+//
+// Copyright 2019, 2020, 2021 Alexander Metzner.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kvlog_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/halimath/kvlog"
+)
+
+func TestWithLazy_resolvedOncePerEvent(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	var calls int
+
+	l := kvlog.New(
+		kvlog.NewSyncHandler(&buf1, kvlog.JSONLFormatter()),
+		kvlog.NewSyncHandler(&buf2, kvlog.KVFormatter),
+	)
+
+	counter := l.Sub(kvlog.WithLazy("calls", func() interface{} {
+		calls++
+		return calls
+	}))
+
+	counter.Logs("first")
+	counter.Logs("second")
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls but got %d", calls)
+	}
+
+	exp := `{"calls":1,"msg":"first"}
+{"calls":2,"msg":"second"}
+`
+	if buf1.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf1.String())
+	}
+
+	exp = "calls=1 msg=first\ncalls=2 msg=second\n"
+	if buf2.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf2.String())
+	}
+}
+
+func TestWithLazy_notResolvedForDiscardedEvents(t *testing.T) {
+	lazy := func() *kvlog.Pair {
+		return kvlog.WithLazy("lazy", func() interface{} {
+			t.Errorf("unexpected call to lazy value")
+			return nil
+		})
+	}
+
+	kvlog.New(kvlog.NoOpHandler()).Logs("noop handler", lazy())
+	kvlog.FromContext(context.Background()).Logs("noop logger", lazy())
+	kvlog.NewWithOptions(kvlog.Options{MinLevel: kvlog.LevelInfo}, kvlog.NoOpHandler()).Debug("disabled", lazy())
+
+	var buf bytes.Buffer
+	kvlog.New(kvlog.NewFilterHandler(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()), kvlog.HasKey("missing"))).
+		Logs("filtered", lazy())
+
+	if buf.Len() > 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+type goroutineCount struct{}
+
+func (goroutineCount) LogValue() interface{} { return 42 }
+
+func TestValuer_group(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := kvlog.New(kvlog.NewSyncHandler(&buf, kvlog.JSONLFormatter()))
+	l.Logs("stats", kvlog.WithGroup("runtime", kvlog.WithKV("goroutines", goroutineCount{})))
+
+	exp := `{"msg":"stats","runtime":{"goroutines":42}}` + "\n"
+	if buf.String() != exp {
+		t.Errorf("expected '%s' but got '%s'", exp, buf.String())
+	}
+}